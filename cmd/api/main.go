@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,7 +14,12 @@ import (
 	"github.com/matchmyvibe/backend/internal/db"
 	"github.com/matchmyvibe/backend/internal/handlers"
 	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/musicsync"
+	"github.com/matchmyvibe/backend/internal/playback"
+	"github.com/matchmyvibe/backend/internal/realtime"
+	"github.com/matchmyvibe/backend/internal/secrets"
 	"github.com/matchmyvibe/backend/internal/spotify"
+	"github.com/matchmyvibe/backend/internal/tokens"
 )
 
 func main() {
@@ -39,6 +46,15 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
+	// Encrypt Spotify tokens at rest when keys are configured
+	database.Keyring, err = secrets.LoadKeyring(getEnv("TOKEN_ENCRYPTION_KEYS", ""), getEnv("TOKEN_ENCRYPTION_KEY_ID", ""))
+	if err != nil {
+		log.Fatalf("Invalid token encryption keys: %v", err)
+	}
+	if database.Keyring == nil {
+		log.Println("Warning: TOKEN_ENCRYPTION_KEYS is not set, Spotify tokens are stored in plaintext")
+	}
+
 	// Set up JWT service
 	jwtSecret := getEnv("JWT_SECRET", "supersecret")
 	jwtDuration := 24 * time.Hour // Token valid for 24 hours
@@ -48,22 +64,128 @@ func main() {
 	spotifyClientID := getEnv("SPOTIFY_CLIENT_ID", "")
 	spotifyClientSecret := getEnv("SPOTIFY_CLIENT_SECRET", "")
 	spotifyRedirectURI := getEnv("SPOTIFY_REDIRECT_URI", "")
-	spotifyClient := spotify.New(spotifyClientID, spotifyClientSecret, spotifyRedirectURI)
+	spotifyClient := spotify.New(spotifyClientID, spotifyClientSecret, spotifyRedirectURI,
+		spotify.WithAccountsURL(getEnv("SPOTIFY_ACCOUNTS_URL", spotify.DefaultAccountsURL)),
+		spotify.WithAPIURL(getEnv("SPOTIFY_API_URL", spotify.DefaultAPIURL)),
+	)
+
+	// Keep Spotify tokens fresh in the background
+	tokenManager := tokens.New(database, spotifyClient)
+	go tokenManager.Start(context.Background())
+
+	// Set up Spotify library sync
+	musicSync := musicsync.New(database, spotifyClient, tokenManager)
+
+	// Copy recently played tracks into listening history in the background
+	historyIngester := musicsync.NewHistoryIngester(musicSync)
+	if interval := getEnv("HISTORY_INGEST_INTERVAL", ""); interval != "" {
+		historyIngester.MinInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Invalid HISTORY_INGEST_INTERVAL: %v", err)
+		}
+	}
+	go historyIngester.Start(context.Background())
+
+	// Fan real-time events out to connected clients. The local broker only
+	// reaches clients connected to this instance.
+	hub, err := realtime.NewHub(realtime.NewLocalBroker())
+	if err != nil {
+		log.Fatalf("Failed to set up real-time hub: %v", err)
+	}
+
+	// Tell matches when what a user is listening to changes
+	playbackNotifier := playback.NewNotifier(database, hub)
+	if cooldown := getEnv("VIBING_COOLDOWN", ""); cooldown != "" {
+		playbackNotifier.VibingCooldown, err = time.ParseDuration(cooldown)
+		if err != nil {
+			log.Fatalf("Invalid VIBING_COOLDOWN: %v", err)
+		}
+	}
+
+	// Poll the player API for users that were recently active
+	playbackPoller := playback.NewPoller(database, spotifyClient, tokenManager)
+	playbackPoller.Notifier = playbackNotifier
+	if window := getEnv("PLAYBACK_POLL_ACTIVE_WINDOW", ""); window != "" {
+		playbackPoller.ActiveWithin, err = time.ParseDuration(window)
+		if err != nil {
+			log.Fatalf("Invalid PLAYBACK_POLL_ACTIVE_WINDOW: %v", err)
+		}
+	}
+	if workers := getEnv("PLAYBACK_POLL_WORKERS", ""); workers != "" {
+		playbackPoller.Workers, err = strconv.Atoi(workers)
+		if err != nil || playbackPoller.Workers < 1 {
+			log.Fatalf("Invalid PLAYBACK_POLL_WORKERS: %q", workers)
+		}
+	}
+	go playbackPoller.Start(context.Background())
+
+	// Clear currently playing once the song has ended
+	go playback.NewSweeper(database).Start(context.Background())
+
+	// The legacy token passthrough login trusts client-supplied tokens, keep it off outside of local testing
+	allowLegacyAuth := getEnv("SPOTIFY_ALLOW_LEGACY_AUTH", "false") == "true"
 
 	// Set up handlers
 	authHandler := &handlers.AuthHandler{
+		DB:                   database,
+		JWTService:           jwtService,
+		SpotifyClient:        spotifyClient,
+		MusicSync:            musicSync,
+		AllowLegacyTokenAuth: allowLegacyAuth,
+	}
+
+	profileHandler := &handlers.ProfileHandler{
 		DB:            database,
-		JWTService:    jwtService,
 		SpotifyClient: spotifyClient,
+		Tokens:        tokenManager,
+		Notifier:      playbackNotifier,
 	}
 
-	profileHandler := &handlers.ProfileHandler{
+	spotifyHandler := &handlers.SpotifyHandler{
+		DB:        database,
+		MusicSync: musicSync,
+	}
+
+	historyHandler := &handlers.HistoryHandler{
+		DB: database,
+	}
+
+	userHandler := &handlers.UserHandler{
+		DB:  database,
+		Hub: hub,
+	}
+
+	discoverHandler := &handlers.DiscoverHandler{
+		DB: database,
+	}
+
+	preferencesHandler := &handlers.PreferencesHandler{
+		DB: database,
+	}
+
+	matchHandler := &handlers.MatchHandler{
 		DB:            database,
+		Hub:           hub,
 		SpotifyClient: spotifyClient,
+		Tokens:        tokenManager,
+	}
+
+	realtimeHandler := &handlers.RealtimeHandler{
+		DB:  database,
+		Hub: hub,
+	}
+
+	// Every request gets a deadline that is passed down to Postgres and Spotify
+	requestTimeout, err := time.ParseDuration(getEnv("REQUEST_TIMEOUT", "15s"))
+	if err != nil {
+		log.Fatalf("Invalid REQUEST_TIMEOUT: %v", err)
 	}
 
 	// Set up router
-	router := gin.Default()
+	// gin.Default() would log the WebSocket ?token= in plain text
+	router := gin.New()
+	router.Use(middleware.Logger(), gin.Recovery())
+	router.Use(middleware.RequestTimeout(requestTimeout))
 
 	// Set up routes
 	authRoutes := router.Group("/auth")
@@ -72,6 +194,9 @@ func main() {
 		authRoutes.POST("/refresh", authHandler.RefreshToken)
 	}
 
+	// Real-time events, authenticated like the protected routes
+	router.GET("/ws", middleware.WebSocketAuthMiddleware(jwtService), realtimeHandler.Connect)
+
 	// Protected routes
 	protectedRoutes := router.Group("/api")
 	protectedRoutes.Use(middleware.AuthMiddleware(jwtService))
@@ -80,6 +205,34 @@ func main() {
 		protectedRoutes.GET("/profile", profileHandler.GetProfile)
 		protectedRoutes.PUT("/profile", profileHandler.UpdateProfile)
 		protectedRoutes.PUT("/profile/currently-playing", profileHandler.UpdateCurrentlyPlaying)
+		protectedRoutes.PUT("/profile/location", profileHandler.UpdateLocation)
+
+		// Spotify routes
+		protectedRoutes.POST("/spotify/sync", spotifyHandler.Sync)
+
+		// Listening history routes
+		protectedRoutes.GET("/me/history", historyHandler.GetHistory)
+
+		// User routes
+		protectedRoutes.GET("/users/:id", userHandler.GetUser)
+		protectedRoutes.GET("/users/:id/compatibility", userHandler.Compatibility)
+		protectedRoutes.POST("/users/:id/like", userHandler.Like)
+		protectedRoutes.POST("/users/:id/pass", userHandler.Pass)
+		protectedRoutes.POST("/users/:id/block", userHandler.Block)
+		protectedRoutes.POST("/users/:id/report", userHandler.Report)
+
+		// Match routes
+		protectedRoutes.GET("/matches", matchHandler.ListMatches)
+		protectedRoutes.DELETE("/matches/:id", matchHandler.Unmatch)
+		protectedRoutes.GET("/matches/:id/messages", matchHandler.GetMessages)
+		protectedRoutes.POST("/matches/:id/messages", matchHandler.SendMessage)
+		protectedRoutes.POST("/matches/:id/read", matchHandler.MarkRead)
+		protectedRoutes.POST("/matches/:id/blend", matchHandler.Blend)
+
+		// Discovery routes
+		protectedRoutes.GET("/discover", discoverHandler.Discover)
+		protectedRoutes.GET("/preferences", preferencesHandler.GetPreferences)
+		protectedRoutes.PUT("/preferences", preferencesHandler.UpdatePreferences)
 	}
 
 	// Start the server
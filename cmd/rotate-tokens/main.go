@@ -0,0 +1,92 @@
+// Command rotate-tokens re-encrypts every user's Spotify tokens with the
+// current token encryption key. It works in small batches while the API keeps
+// running, so deploy the new key to the API first, run this, and only then
+// remove the old key.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/secrets"
+)
+
+func main() {
+	batchSize := flag.Int("batch", 100, "number of users re-encrypted per transaction")
+	retries := flag.Int("retries", 5, "passes over users that were locked by token refreshes before giving up")
+	retryDelay := flag.Duration("retry-delay", 5*time.Second, "wait between passes over locked users")
+	flag.Parse()
+
+	// Load environment variables
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: No .env file found")
+	}
+
+	dbConnStr := fmt.Sprintf(
+		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		getEnv("DB_HOST", "localhost"), getEnv("DB_PORT", "5432"), getEnv("DB_USER", "postgres"),
+		getEnv("DB_PASSWORD", "postgres"), getEnv("DB_NAME", "matchmyvibe"), getEnv("DB_SSLMODE", "disable"),
+	)
+
+	database, err := db.New(dbConnStr)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	database.Keyring, err = secrets.LoadKeyring(getEnv("TOKEN_ENCRYPTION_KEYS", ""), getEnv("TOKEN_ENCRYPTION_KEY_ID", ""))
+	if err != nil {
+		log.Fatalf("Invalid token encryption keys: %v", err)
+	}
+	if database.Keyring == nil {
+		log.Fatal("TOKEN_ENCRYPTION_KEYS is not set")
+	}
+
+	ctx := context.Background()
+	total := 0
+	for attempt := 0; ; attempt++ {
+		for {
+			rotated, err := database.RotateTokenEncryption(ctx, *batchSize)
+			if err != nil {
+				log.Fatalf("Failed to rotate tokens after %d users: %v", total, err)
+			}
+			if rotated == 0 {
+				break
+			}
+			total += rotated
+			log.Printf("Re-encrypted tokens for %d users", total)
+		}
+
+		// Users whose tokens were being refreshed were skipped, go over them
+		// again once the refreshes are done
+		remaining, err := database.CountUnrotatedTokens(ctx)
+		if err != nil {
+			log.Fatalf("Failed to count users left to rotate: %v", err)
+		}
+		if remaining == 0 {
+			break
+		}
+		if attempt == *retries {
+			log.Fatalf("Tokens of %d users are still not encrypted with key %s, keep the old key and run again", remaining, getEnv("TOKEN_ENCRYPTION_KEY_ID", ""))
+		}
+		log.Printf("Tokens of %d users were locked by refreshes, retrying in %v", remaining, *retryDelay)
+		time.Sleep(*retryDelay)
+	}
+
+	log.Printf("Done, tokens of %d users re-encrypted with key %s", total, getEnv("TOKEN_ENCRYPTION_KEY_ID", ""))
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -53,6 +53,15 @@ func (j *JWTService) GenerateToken(userID uuid.UUID) (string, error) {
 
 // ValidateToken validates a JWT token and returns the user ID
 func (j *JWTService) ValidateToken(tokenString string) (uuid.UUID, error) {
+	claims, err := j.ParseToken(tokenString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+// ParseToken validates a JWT token and returns its claims
+func (j *JWTService) ParseToken(tokenString string) (*CustomClaims, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&CustomClaims{},
@@ -66,13 +75,13 @@ func (j *JWTService) ValidateToken(tokenString string) (uuid.UUID, error) {
 	)
 
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("failed to parse token: %w", err)
+		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
 	claims, ok := token.Claims.(*CustomClaims)
 	if !ok || !token.Valid {
-		return uuid.Nil, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	return claims.UserID, nil
+	return claims, nil
 }
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// BlendLock is held while a user's blend of a match is created or refreshed
+type BlendLock struct {
+	tx *sql.Tx
+}
+
+// LockMatchBlend waits until no other request is blending the match for the
+// user and takes the lock, so concurrent blends can't create two playlists.
+// Callers must Release it.
+func (db *DB) LockMatchBlend(ctx context.Context, matchID, userID uuid.UUID) (*BlendLock, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The lock is transaction scoped, so it is released even if the
+	// connection breaks before Release
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, "blend:"+matchID.String()+":"+userID.String())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &BlendLock{tx: tx}, nil
+}
+
+// Release releases the lock
+func (l *BlendLock) Release() {
+	l.tx.Rollback()
+}
+
+// GetMatchBlend retrieves the user's blended playlist for a match
+func (db *DB) GetMatchBlend(ctx context.Context, matchID, userID uuid.UUID) (*models.MatchBlend, error) {
+	query := `SELECT match_id, user_id, playlist_id, playlist_uri, track_count, created_at, updated_at
+			 FROM match_blends WHERE match_id = $1 AND user_id = $2`
+
+	var blend models.MatchBlend
+	err := db.QueryRowContext(ctx, query, matchID, userID).Scan(
+		&blend.MatchID, &blend.UserID, &blend.PlaylistID, &blend.PlaylistURI, &blend.TrackCount, &blend.CreatedAt, &blend.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &blend, nil
+}
+
+// SaveMatchBlend stores the user's blended playlist for a match, replacing the
+// previous one, and fills in its timestamps
+func (db *DB) SaveMatchBlend(ctx context.Context, blend *models.MatchBlend) error {
+	query := `INSERT INTO match_blends (match_id, user_id, playlist_id, playlist_uri, track_count, created_at, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+			 ON CONFLICT (match_id, user_id) DO UPDATE
+			 SET playlist_id = EXCLUDED.playlist_id, playlist_uri = EXCLUDED.playlist_uri,
+				 track_count = EXCLUDED.track_count, updated_at = NOW(),
+				 created_at = CASE WHEN match_blends.playlist_id = EXCLUDED.playlist_id
+					 THEN match_blends.created_at ELSE NOW() END
+			 RETURNING created_at, updated_at`
+
+	return db.QueryRowContext(ctx, query,
+		blend.MatchID, blend.UserID, blend.PlaylistID, blend.PlaylistURI, blend.TrackCount,
+	).Scan(&blend.CreatedAt, &blend.UpdatedAt)
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// Block records that blockerID blocked blockedID and ends any match between
+// them, which hides its conversation and stops its real-time events. Blocking
+// someone again does nothing.
+func (db *DB) Block(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// A like landing at the same time must see the block and not match
+	if err := lockPair(ctx, tx, blockerID, blockedID); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO blocks (blocker_id, blocked_id, created_at) VALUES ($1, $2, NOW())
+			 ON CONFLICT (blocker_id, blocked_id) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, blockerID, blockedID); err != nil {
+		return err
+	}
+
+	userA, userB := orderPair(blockerID, blockedID)
+	query = `UPDATE matches SET unmatched_at = NOW(), unmatched_by = $1
+			 WHERE user_a = $2 AND user_b = $3 AND unmatched_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, blockerID, userA, userB); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsBlocked reports whether either of the two users blocked the other
+func (db *DB) IsBlocked(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM blocks
+			 WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1))`
+
+	var blocked bool
+	err := db.QueryRowContext(ctx, query, a, b).Scan(&blocked)
+	return blocked, err
+}
+
+// CreateReport stores a new open report and fills in its id, status and
+// creation time
+func (db *DB) CreateReport(ctx context.Context, report *models.Report) error {
+	report.ID = uuid.New()
+	report.Status = models.ReportStatusOpen
+
+	query := `INSERT INTO reports (id, reporter_id, reported_id, reason, details, status, created_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, NOW())
+			 RETURNING created_at`
+
+	return db.QueryRowContext(ctx, query,
+		report.ID, report.ReporterID, report.ReportedID, report.Reason, report.Details, report.Status,
+	).Scan(&report.CreatedAt)
+}
@@ -1,19 +1,32 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/secrets"
 )
 
 // DB represents the database connection
 type DB struct {
 	*sql.DB
+
+	// Keyring encrypts Spotify tokens at rest. Tokens are stored in plaintext
+	// when it is nil.
+	Keyring *secrets.Keyring
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx so writes can share code
+// inside and outside of transactions
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
 // New creates a new database connection
@@ -27,34 +40,49 @@ func New(connStr string) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{db}, nil
+	return &DB{DB: db}, nil
 }
 
-// GetUserByID retrieves a user by their ID
-func (db *DB) GetUserByID(userID uuid.UUID) (*models.User, error) {
+// userColumns lists the users columns read by scanUser, in scan order
+const userColumns = `id, spotify_uri, access_token, refresh_token, token_expiry, needs_reauth,
+			 name, university_name, work, home_town, height, age, zodiac,
+			 currently_playing, "birthdayInUnix", gender, dating_preference,
+			 last_played_song, user_last_active_at, latitude, longitude, geohash,
+			 location_updated_at, created_at, updated_at`
+
+// prefixColumns qualifies every column in a comma separated list with a table alias
+func prefixColumns(alias, columns string) string {
+	parts := strings.Split(columns, ",")
+	for i, part := range parts {
+		parts[i] = alias + "." + strings.TrimSpace(part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans a row selected with userColumns into a user, decrypting
+// their Spotify tokens
+func (db *DB) scanUser(row rowScanner) (*models.User, error) {
 	var user models.User
 	var workJSON []byte
 	var lastPlayedSongJSON []byte
+	var location nullLocation
 
-	query := `SELECT id, spotify_uri, access_token, refresh_token, token_expiry, 
-			 name, university_name, work, home_town, height, age, zodiac, 
-			 currently_playing, "birthdayInUnix", gender, dating_preference, 
-			 last_played_song, user_last_active_at, created_at, updated_at 
-			 FROM users WHERE id = $1`
-
-	err := db.QueryRow(query, userID).Scan(
-		&user.ID, &user.SpotifyURI, &user.AccessToken, &user.RefreshToken, &user.TokenExpiry,
+	err := row.Scan(
+		&user.ID, &user.SpotifyURI, &user.AccessToken, &user.RefreshToken, &user.TokenExpiry, &user.NeedsReauth,
 		&user.Name, &user.UniversityName, &workJSON, &user.HomeTown, &user.Height, &user.Age, &user.Zodiac,
 		&user.CurrentlyPlaying, &user.BirthdayInUnix, &user.Gender, &user.DatingPreference,
-		&lastPlayedSongJSON, &user.UserLastActiveAt, &user.CreatedAt, &user.UpdatedAt,
+		&lastPlayedSongJSON, &user.UserLastActiveAt, &location.Latitude, &location.Longitude, &location.Geohash,
+		&location.UpdatedAt, &user.CreatedAt, &user.UpdatedAt,
 	)
-
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // User not found
-		}
 		return nil, err
 	}
+	user.Location = location.location()
 
 	// Parse work JSON if it exists
 	if len(workJSON) > 0 {
@@ -74,31 +102,39 @@ func (db *DB) GetUserByID(userID uuid.UUID) (*models.User, error) {
 		user.LastPlayedSong = &lastPlayedSong
 	}
 
+	if user.AccessToken, err = db.openToken(user.ID, "access_token", user.AccessToken); err != nil {
+		return nil, err
+	}
+	if user.RefreshToken, err = db.openToken(user.ID, "refresh_token", user.RefreshToken); err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
 
-// GetUserBySpotifyURI retrieves a user by their Spotify URI
-func (db *DB) GetUserBySpotifyURI(spotifyURI string) (*models.User, error) {
-	var user models.User
-	var workJSON []byte
-	var lastPlayedSongJSON []byte
+// GetUserByID retrieves a user by their ID
+func (db *DB) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
 
-	query := `SELECT id, spotify_uri, access_token, refresh_token, token_expiry, 
-			 name, university_name, work, home_town, height, age, zodiac, 
-			 currently_playing, "birthdayInUnix", gender, dating_preference,
-			 last_played_song, user_last_active_at, created_at, updated_at 
-			 FROM users WHERE spotify_uri = $1`
+	user, err := db.scanUser(db.QueryRowContext(ctx, query, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // User not found
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetUserBySpotifyURI retrieves a user by their Spotify URI
+func (db *DB) GetUserBySpotifyURI(ctx context.Context, spotifyURI string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE spotify_uri = $1`
 
 	fmt.Println("[DEBUG] Query:", query)
 	fmt.Println("[DEBUG] Spotify URI:", spotifyURI)
 
-	err := db.QueryRow(query, spotifyURI).Scan(
-		&user.ID, &user.SpotifyURI, &user.AccessToken, &user.RefreshToken, &user.TokenExpiry,
-		&user.Name, &user.UniversityName, &workJSON, &user.HomeTown, &user.Height, &user.Age, &user.Zodiac,
-		&user.CurrentlyPlaying, &user.BirthdayInUnix, &user.Gender, &user.DatingPreference,
-		&lastPlayedSongJSON, &user.UserLastActiveAt, &user.CreatedAt, &user.UpdatedAt,
-	)
-
+	user, err := db.scanUser(db.QueryRowContext(ctx, query, spotifyURI))
 	if err != nil {
 		fmt.Println("[DEBUG] Error fetching user by Spotify URI:", err)
 		if err == sql.ErrNoRows {
@@ -107,35 +143,98 @@ func (db *DB) GetUserBySpotifyURI(spotifyURI string) (*models.User, error) {
 		return nil, err
 	}
 
-	// Parse work JSON if it exists
-	if len(workJSON) > 0 {
-		var work models.WorkProfile
-		if err := json.Unmarshal(workJSON, &work); err != nil {
-			return nil, err
-		}
-		user.Work = &work
+	return user, nil
+}
+
+// GetUsersWithExpiringTokens retrieves users active since activeSince whose
+// Spotify access token expires before the given time, soonest first. Users
+// that must log in again or whose last refresh failed recently are skipped.
+func (db *DB) GetUsersWithExpiringTokens(ctx context.Context, before, activeSince time.Time, limit int) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+			 WHERE token_expiry < $1 AND NOT needs_reauth
+			 AND user_last_active_at >= $2
+			 AND (token_refresh_retry_at IS NULL OR token_refresh_retry_at <= NOW())
+			 ORDER BY token_expiry LIMIT $3`
+
+	return db.queryUsers(ctx, query, before, activeSince.Unix(), limit)
+}
+
+// RecordTokenRefreshFailure backs off refreshing a user's tokens after a
+// failed refresh. The delay starts at backoff and doubles with every further
+// failure, up to maxBackoff.
+func (db *DB) RecordTokenRefreshFailure(ctx context.Context, userID uuid.UUID, backoff, maxBackoff time.Duration) error {
+	query := `UPDATE users SET token_refresh_failures = token_refresh_failures + 1,
+			 token_refresh_retry_at = NOW() + LEAST($1 * POWER(2, LEAST(token_refresh_failures, 30)), $2) * INTERVAL '1 second'
+			 WHERE id = $3`
+	_, err := db.ExecContext(ctx, query, backoff.Seconds(), maxBackoff.Seconds(), userID)
+	return err
+}
+
+// GetUsersActiveSince retrieves users whose last activity is at or after the
+// given time, skipping users that must log in again
+func (db *DB) GetUsersActiveSince(ctx context.Context, since time.Time) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+			 WHERE user_last_active_at >= $1 AND NOT needs_reauth
+			 ORDER BY user_last_active_at DESC`
+
+	return db.queryUsers(ctx, query, since.Unix())
+}
+
+// ClearEndedCurrentlyPlaying clears currently_playing for users whose last
+// played song must have ended by now. It mirrors models.LastPlayedSong.IsLive
+// and returns how many users were updated.
+func (db *DB) ClearEndedCurrentlyPlaying(ctx context.Context, now time.Time) (int64, error) {
+	query := `UPDATE users SET currently_playing = NULL, updated_at = NOW()
+			 WHERE currently_playing IS NOT NULL
+			 AND (last_played_song IS NULL
+				OR NOT COALESCE((last_played_song->>'is_playing')::boolean, FALSE)
+				OR COALESCE(NULLIF((last_played_song->>'updated_at')::bigint, 0), user_last_active_at * 1000, 0)
+					- COALESCE((last_played_song->>'progress_ms')::bigint, 0)
+					+ COALESCE(NULLIF((last_played_song->>'duration')::bigint, 0), $1)
+					+ $2 <= $3)`
+
+	result, err := db.ExecContext(ctx, query,
+		models.UnknownDuration.Milliseconds(), models.LiveGrace.Milliseconds(), now.UnixMilli(),
+	)
+	if err != nil {
+		return 0, err
 	}
+	return result.RowsAffected()
+}
 
-	// Parse last played song JSON if it exists
-	if len(lastPlayedSongJSON) > 0 {
-		var lastPlayedSong models.LastPlayedSong
-		if err := json.Unmarshal(lastPlayedSongJSON, &lastPlayedSong); err != nil {
+// queryUsers runs a query selecting userColumns and scans every row
+func (db *DB) queryUsers(ctx context.Context, query string, args ...interface{}) ([]models.User, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := db.scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		user.LastPlayedSong = &lastPlayedSong
+		users = append(users, *user)
 	}
 
-	return &user, nil
+	return users, rows.Err()
 }
 
 // CreateUser creates a new user with Spotify authentication details
-func (db *DB) CreateUser(spotifyURI, accessToken, refreshToken string, tokenExpiry time.Time) (*models.User, error) {
+func (db *DB) CreateUser(ctx context.Context, spotifyURI, accessToken, refreshToken string, tokenExpiry time.Time) (*models.User, error) {
 	id := uuid.New()
 	query := `INSERT INTO users (id, spotify_uri, access_token, refresh_token, token_expiry, created_at, updated_at) 
 			 VALUES ($1, $2, $3, $4, $5, NOW(), NOW()) RETURNING id, created_at, updated_at`
 
+	sealedAccess, sealedRefresh, err := db.sealTokens(id, accessToken, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
 	var user models.User
-	err := db.QueryRow(query, id, spotifyURI, accessToken, refreshToken, tokenExpiry).Scan(
+	err = db.QueryRowContext(ctx, query, id, spotifyURI, sealedAccess, sealedRefresh, tokenExpiry).Scan(
 		&user.ID, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -151,7 +250,7 @@ func (db *DB) CreateUser(spotifyURI, accessToken, refreshToken string, tokenExpi
 }
 
 // UpdateUser updates user profile information
-func (db *DB) UpdateUser(user *models.User) error {
+func (db *DB) UpdateUser(ctx context.Context, user *models.User) error {
 	fmt.Printf("[DEBUG] UpdateUser - Updating user %s with BirthdayInUnix=%v, Gender=%v, DatingPreference=%v\n",
 		user.ID, user.BirthdayInUnix, user.Gender, user.DatingPreference)
 
@@ -173,17 +272,19 @@ func (db *DB) UpdateUser(user *models.User) error {
 	query := `UPDATE users SET 
 			 name = $1, university_name = $2, work = $3, home_town = $4, 
 			 height = $5, zodiac = $6, currently_playing = $7, "birthdayInUnix" = $8,
-			 gender = $9, dating_preference = $10, last_played_song = $11, user_last_active_at = $12, updated_at = NOW() 
+			 gender = $9, dating_preference = $10, last_played_song = $11, user_last_active_at = $12,
+			 height_cm = $14, updated_at = NOW() 
 			 WHERE id = $13`
 
 	fmt.Printf("[DEBUG] UpdateUser - Executing query: %s\n", query)
 	fmt.Printf("[DEBUG] UpdateUser - Query params: name=%v, birthdayInUnix=%v, gender=%v, dating_preference=%v\n",
 		user.Name, user.BirthdayInUnix, user.Gender, user.DatingPreference)
 
-	result, err := db.Exec(query,
+	result, err := db.ExecContext(ctx, query,
 		user.Name, user.UniversityName, workJSON, user.HomeTown,
 		user.Height, user.Zodiac, user.CurrentlyPlaying, user.BirthdayInUnix,
 		user.Gender, user.DatingPreference, lastPlayedSongJSON, user.UserLastActiveAt, user.ID,
+		user.HeightCM(),
 	)
 
 	if err != nil {
@@ -197,32 +298,48 @@ func (db *DB) UpdateUser(user *models.User) error {
 	return nil
 }
 
-// UpdateSpotifyTokens updates a user's Spotify access token, refresh token, and expiry
-func (db *DB) UpdateSpotifyTokens(userID uuid.UUID, accessToken, refreshToken string, tokenExpiry time.Time) error {
-	query := `UPDATE users SET access_token = $1, refresh_token = $2, token_expiry = $3, updated_at = NOW() WHERE id = $4`
-	_, err := db.Exec(query, accessToken, refreshToken, tokenExpiry, userID)
+// UpdateSpotifyTokens updates a user's Spotify access token, refresh token, and
+// expiry. Storing working tokens also clears the needs_reauth flag and any
+// refresh backoff.
+func (db *DB) UpdateSpotifyTokens(ctx context.Context, userID uuid.UUID, accessToken, refreshToken string, tokenExpiry time.Time) error {
+	sealedAccess, sealedRefresh, err := db.sealTokens(userID, accessToken, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE users SET access_token = $1, refresh_token = $2, token_expiry = $3, needs_reauth = FALSE,
+			 token_refresh_failures = 0, token_refresh_retry_at = NULL, updated_at = NOW() WHERE id = $4`
+	_, err = db.ExecContext(ctx, query, sealedAccess, sealedRefresh, tokenExpiry, userID)
+	return err
+}
+
+// MarkNeedsReauth flags that a user's Spotify refresh token was revoked and
+// they have to log in again before we can call Spotify for them
+func (db *DB) MarkNeedsReauth(ctx context.Context, userID uuid.UUID) error {
+	query := `UPDATE users SET needs_reauth = TRUE, updated_at = NOW() WHERE id = $1`
+	_, err := db.ExecContext(ctx, query, userID)
 	return err
 }
 
 // SaveImage saves a user's image
-func (db *DB) SaveImage(userID uuid.UUID, imageData []byte) error {
+func (db *DB) SaveImage(ctx context.Context, userID uuid.UUID, imageData []byte) error {
 	imageID := uuid.New()
 	query := `INSERT INTO images (id, user_id, data) VALUES ($1, $2, $3)`
-	_, err := db.Exec(query, imageID, userID, imageData)
+	_, err := db.ExecContext(ctx, query, imageID, userID, imageData)
 	return err
 }
 
 // ClearUserImages removes all images for a user
-func (db *DB) ClearUserImages(userID uuid.UUID) error {
+func (db *DB) ClearUserImages(ctx context.Context, userID uuid.UUID) error {
 	query := `DELETE FROM images WHERE user_id = $1`
-	_, err := db.Exec(query, userID)
+	_, err := db.ExecContext(ctx, query, userID)
 	return err
 }
 
 // GetUserImages retrieves all images for a user
-func (db *DB) GetUserImages(userID uuid.UUID) ([][]byte, error) {
+func (db *DB) GetUserImages(ctx context.Context, userID uuid.UUID) ([][]byte, error) {
 	query := `SELECT data FROM images WHERE user_id = $1`
-	rows, err := db.Query(query, userID)
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -241,24 +358,24 @@ func (db *DB) GetUserImages(userID uuid.UUID) ([][]byte, error) {
 }
 
 // SaveInterest saves a user's interest
-func (db *DB) SaveInterest(userID uuid.UUID, interestName string) error {
+func (db *DB) SaveInterest(ctx context.Context, userID uuid.UUID, interestName string) error {
 	interestID := uuid.New()
 	query := `INSERT INTO interests (id, user_id, name) VALUES ($1, $2, $3)`
-	_, err := db.Exec(query, interestID, userID, interestName)
+	_, err := db.ExecContext(ctx, query, interestID, userID, interestName)
 	return err
 }
 
 // ClearUserInterests removes all interests for a user
-func (db *DB) ClearUserInterests(userID uuid.UUID) error {
+func (db *DB) ClearUserInterests(ctx context.Context, userID uuid.UUID) error {
 	query := `DELETE FROM interests WHERE user_id = $1`
-	_, err := db.Exec(query, userID)
+	_, err := db.ExecContext(ctx, query, userID)
 	return err
 }
 
 // GetUserInterests retrieves all interests for a user
-func (db *DB) GetUserInterests(userID uuid.UUID) ([]string, error) {
+func (db *DB) GetUserInterests(ctx context.Context, userID uuid.UUID) ([]string, error) {
 	query := `SELECT name FROM interests WHERE user_id = $1`
-	rows, err := db.Query(query, userID)
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -277,26 +394,26 @@ func (db *DB) GetUserInterests(userID uuid.UUID) ([]string, error) {
 }
 
 // SaveInterestRating saves a user's interest rating
-func (db *DB) SaveInterestRating(userID uuid.UUID, interestName string, rating int) error {
+func (db *DB) SaveInterestRating(ctx context.Context, userID uuid.UUID, interestName string, rating int) error {
 	interestRatingID := uuid.New()
 	query := `INSERT INTO interest_ratings (id, user_id, name, rating) 
 			 VALUES ($1, $2, $3, $4) 
 			 ON CONFLICT (user_id, name) DO UPDATE SET rating = $4`
-	_, err := db.Exec(query, interestRatingID, userID, interestName, rating)
+	_, err := db.ExecContext(ctx, query, interestRatingID, userID, interestName, rating)
 	return err
 }
 
 // ClearUserInterestRatings removes all interest ratings for a user
-func (db *DB) ClearUserInterestRatings(userID uuid.UUID) error {
+func (db *DB) ClearUserInterestRatings(ctx context.Context, userID uuid.UUID) error {
 	query := `DELETE FROM interest_ratings WHERE user_id = $1`
-	_, err := db.Exec(query, userID)
+	_, err := db.ExecContext(ctx, query, userID)
 	return err
 }
 
 // GetUserInterestRatings retrieves all interest ratings for a user
-func (db *DB) GetUserInterestRatings(userID uuid.UUID) (map[string]int, error) {
+func (db *DB) GetUserInterestRatings(ctx context.Context, userID uuid.UUID) (map[string]int, error) {
 	query := `SELECT name, rating FROM interest_ratings WHERE user_id = $1`
-	rows, err := db.Query(query, userID)
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -316,24 +433,24 @@ func (db *DB) GetUserInterestRatings(userID uuid.UUID) (map[string]int, error) {
 }
 
 // SavePrompt saves a user's prompt
-func (db *DB) SavePrompt(userID uuid.UUID, question, answer string) error {
+func (db *DB) SavePrompt(ctx context.Context, userID uuid.UUID, question, answer string) error {
 	promptID := uuid.New()
 	query := `INSERT INTO prompts (id, user_id, question, answer) VALUES ($1, $2, $3, $4)`
-	_, err := db.Exec(query, promptID, userID, question, answer)
+	_, err := db.ExecContext(ctx, query, promptID, userID, question, answer)
 	return err
 }
 
 // ClearUserPrompts removes all prompts for a user
-func (db *DB) ClearUserPrompts(userID uuid.UUID) error {
+func (db *DB) ClearUserPrompts(ctx context.Context, userID uuid.UUID) error {
 	query := `DELETE FROM prompts WHERE user_id = $1`
-	_, err := db.Exec(query, userID)
+	_, err := db.ExecContext(ctx, query, userID)
 	return err
 }
 
 // GetUserPrompts retrieves all prompts for a user
-func (db *DB) GetUserPrompts(userID uuid.UUID) ([]models.Prompt, error) {
+func (db *DB) GetUserPrompts(ctx context.Context, userID uuid.UUID) ([]models.Prompt, error) {
 	query := `SELECT id, question, answer FROM prompts WHERE user_id = $1`
-	rows, err := db.Query(query, userID)
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -352,18 +469,22 @@ func (db *DB) GetUserPrompts(userID uuid.UUID) ([]models.Prompt, error) {
 	return prompts, nil
 }
 
-// SaveArtist saves a user's top artist
-func (db *DB) SaveArtist(userID uuid.UUID, name, uri string, imageURL *string) error {
+// SaveArtist saves a user's top artist at the given rank
+func (db *DB) SaveArtist(ctx context.Context, userID uuid.UUID, name, uri string, imageURL *string, rank int) error {
+	return saveArtist(ctx, db.DB, userID, name, uri, imageURL, rank)
+}
+
+func saveArtist(ctx context.Context, ex execer, userID uuid.UUID, name, uri string, imageURL *string, rank int) error {
 	artistID := uuid.New()
-	query := `INSERT INTO artists (id, user_id, name, uri, image_url) VALUES ($1, $2, $3, $4, $5)`
-	_, err := db.Exec(query, artistID, userID, name, uri, imageURL)
+	query := `INSERT INTO artists (id, user_id, name, uri, image_url, rank) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := ex.ExecContext(ctx, query, artistID, userID, name, uri, imageURL, rank)
 	return err
 }
 
 // GetUserArtists retrieves all top artists for a user
-func (db *DB) GetUserArtists(userID uuid.UUID) ([]models.Artist, error) {
-	query := `SELECT id, name, uri, image_url FROM artists WHERE user_id = $1`
-	rows, err := db.Query(query, userID)
+func (db *DB) GetUserArtists(ctx context.Context, userID uuid.UUID) ([]models.Artist, error) {
+	query := `SELECT id, name, uri, image_url, rank FROM artists WHERE user_id = $1 ORDER BY rank, name`
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -372,7 +493,7 @@ func (db *DB) GetUserArtists(userID uuid.UUID) ([]models.Artist, error) {
 	var artists []models.Artist
 	for rows.Next() {
 		var artist models.Artist
-		if err := rows.Scan(&artist.ID, &artist.Name, &artist.Uri, &artist.ImageURL); err != nil {
+		if err := rows.Scan(&artist.ID, &artist.Name, &artist.Uri, &artist.ImageURL, &artist.Rank); err != nil {
 			return nil, err
 		}
 		artist.UserID = userID
@@ -382,18 +503,22 @@ func (db *DB) GetUserArtists(userID uuid.UUID) ([]models.Artist, error) {
 	return artists, nil
 }
 
-// SaveSong saves a user's top song
-func (db *DB) SaveSong(userID uuid.UUID, name, artist, uri string, imageURL *string) error {
+// SaveSong saves a user's top song at the given rank
+func (db *DB) SaveSong(ctx context.Context, userID uuid.UUID, name, artist, uri string, imageURL *string, rank int) error {
+	return saveSong(ctx, db.DB, userID, name, artist, uri, imageURL, rank)
+}
+
+func saveSong(ctx context.Context, ex execer, userID uuid.UUID, name, artist, uri string, imageURL *string, rank int) error {
 	songID := uuid.New()
-	query := `INSERT INTO songs (id, user_id, name, artist, uri, image_url) VALUES ($1, $2, $3, $4, $5, $6)`
-	_, err := db.Exec(query, songID, userID, name, artist, uri, imageURL)
+	query := `INSERT INTO songs (id, user_id, name, artist, uri, image_url, rank) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := ex.ExecContext(ctx, query, songID, userID, name, artist, uri, imageURL, rank)
 	return err
 }
 
 // GetUserSongs retrieves all top songs for a user
-func (db *DB) GetUserSongs(userID uuid.UUID) ([]models.Song, error) {
-	query := `SELECT id, name, artist, uri, image_url FROM songs WHERE user_id = $1`
-	rows, err := db.Query(query, userID)
+func (db *DB) GetUserSongs(ctx context.Context, userID uuid.UUID) ([]models.Song, error) {
+	query := `SELECT id, name, artist, uri, image_url, rank FROM songs WHERE user_id = $1 ORDER BY rank, name`
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -402,7 +527,7 @@ func (db *DB) GetUserSongs(userID uuid.UUID) ([]models.Song, error) {
 	var songs []models.Song
 	for rows.Next() {
 		var song models.Song
-		if err := rows.Scan(&song.ID, &song.Name, &song.Artist, &song.Uri, &song.ImageURL); err != nil {
+		if err := rows.Scan(&song.ID, &song.Name, &song.Artist, &song.Uri, &song.ImageURL, &song.Rank); err != nil {
 			return nil, err
 		}
 		song.UserID = userID
@@ -412,18 +537,175 @@ func (db *DB) GetUserSongs(userID uuid.UUID) ([]models.Song, error) {
 	return songs, nil
 }
 
+// TopItems is everything a top items sync stores for a user
+type TopItems struct {
+	Artists []models.Artist
+	Songs   []models.Song
+	// Genres is the user's genre distribution derived from the artists and songs
+	Genres []models.GenreShare
+	// VibeVector replaces the user's vibe vector unless it is nil
+	VibeVector *models.VibeVector
+}
+
+// ReplaceUserTopItems replaces a user's top artists, songs and genre
+// distribution in a single transaction and records when the sync happened
+func (db *DB) ReplaceUserTopItems(ctx context.Context, userID uuid.UUID, items TopItems, syncedAt time.Time) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The login sync and a manual sync may run at the same time, locking the
+	// user makes the second wait instead of interleaving its inserts
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM artists WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, artist := range items.Artists {
+		if err := saveArtist(ctx, tx, userID, artist.Name, artist.Uri, artist.ImageURL, artist.Rank); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM songs WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, song := range items.Songs {
+		if err := saveSong(ctx, tx, userID, song.Name, song.Artist, song.Uri, song.ImageURL, song.Rank); err != nil {
+			return err
+		}
+	}
+
+	if err := replaceUserGenres(ctx, tx, userID, items.Genres); err != nil {
+		return err
+	}
+
+	if items.VibeVector != nil {
+		if err := saveVibeVector(ctx, tx, userID, items.VibeVector); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET top_items_synced_at = $1 WHERE id = $2`, syncedAt, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetTopItemsSyncedAt returns when a user's top items were last synced, or nil if never
+func (db *DB) GetTopItemsSyncedAt(ctx context.Context, userID uuid.UUID) (*time.Time, error) {
+	var syncedAt sql.NullTime
+	err := db.QueryRowContext(ctx, `SELECT top_items_synced_at FROM users WHERE id = $1`, userID).Scan(&syncedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !syncedAt.Valid {
+		return nil, nil
+	}
+	return &syncedAt.Time, nil
+}
+
 // SavePlaylist saves a user's saved playlist
-func (db *DB) SavePlaylist(userID uuid.UUID, name, uri string, imageURL *string) error {
+func (db *DB) SavePlaylist(ctx context.Context, userID uuid.UUID, name, uri string, imageURL *string) error {
 	playlistID := uuid.New()
 	query := `INSERT INTO playlists (id, user_id, name, uri, image_url) VALUES ($1, $2, $3, $4, $5)`
-	_, err := db.Exec(query, playlistID, userID, name, uri, imageURL)
+	_, err := db.ExecContext(ctx, query, playlistID, userID, name, uri, imageURL)
 	return err
 }
 
+// ReconcileUserPlaylists makes the user's saved playlists match the given
+// list: new playlists are inserted, missing ones deleted and renamed or
+// re-covered ones updated. Playlists are matched by URI.
+func (db *DB) ReconcileUserPlaylists(ctx context.Context, userID uuid.UUID, playlists []models.Playlist) (added, removed, updated int, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the user's rows so concurrent syncs can't both insert the same playlist
+	rows, err := tx.QueryContext(ctx, `SELECT uri, name, image_url FROM playlists WHERE user_id = $1 FOR UPDATE`, userID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	existing := make(map[string]models.Playlist)
+	for rows.Next() {
+		var playlist models.Playlist
+		if err := rows.Scan(&playlist.Uri, &playlist.Name, &playlist.ImageURL); err != nil {
+			rows.Close()
+			return 0, 0, 0, err
+		}
+		existing[playlist.Uri] = playlist
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	seen := make(map[string]bool)
+	for _, playlist := range playlists {
+		if seen[playlist.Uri] {
+			continue
+		}
+		seen[playlist.Uri] = true
+
+		current, ok := existing[playlist.Uri]
+		if !ok {
+			query := `INSERT INTO playlists (id, user_id, name, uri, image_url) VALUES ($1, $2, $3, $4, $5)
+					 ON CONFLICT (user_id, uri) DO NOTHING`
+			if _, err := tx.ExecContext(ctx, query, uuid.New(), userID, playlist.Name, playlist.Uri, playlist.ImageURL); err != nil {
+				return 0, 0, 0, err
+			}
+			added++
+			continue
+		}
+
+		if current.Name != playlist.Name || !equalStringPtr(current.ImageURL, playlist.ImageURL) {
+			query := `UPDATE playlists SET name = $1, image_url = $2 WHERE user_id = $3 AND uri = $4`
+			if _, err := tx.ExecContext(ctx, query, playlist.Name, playlist.ImageURL, userID, playlist.Uri); err != nil {
+				return 0, 0, 0, err
+			}
+			updated++
+		}
+	}
+
+	for uri := range existing {
+		if seen[uri] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM playlists WHERE user_id = $1 AND uri = $2`, userID, uri); err != nil {
+			return 0, 0, 0, err
+		}
+		removed++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, 0, err
+	}
+	return added, removed, updated, nil
+}
+
+// equalStringPtr reports whether two optional strings hold the same value
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
 // GetUserPlaylists retrieves all saved playlists for a user
-func (db *DB) GetUserPlaylists(userID uuid.UUID) ([]models.Playlist, error) {
-	query := `SELECT id, name, uri, image_url FROM playlists WHERE user_id = $1`
-	rows, err := db.Query(query, userID)
+func (db *DB) GetUserPlaylists(ctx context.Context, userID uuid.UUID) ([]models.Playlist, error) {
+	query := `SELECT id, name, uri, image_url FROM playlists WHERE user_id = $1 ORDER BY name`
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -443,39 +725,28 @@ func (db *DB) GetUserPlaylists(userID uuid.UUID) ([]models.Playlist, error) {
 }
 
 // GetFullUserProfile retrieves the complete user profile
-func (db *DB) GetFullUserProfile(userID uuid.UUID) (*models.UserProfile, error) {
+func (db *DB) GetFullUserProfile(ctx context.Context, userID uuid.UUID) (*models.UserProfile, error) {
 	fmt.Println("[DEBUG] GetFullUserProfile - Retrieving profile for user:", userID)
 
 	// First, get the user from GetUserByID to ensure we have all fields
-	user, err := db.GetUserByID(userID)
+	user, err := db.GetUserByID(ctx, userID)
 	if err != nil {
 		fmt.Printf("[ERROR] GetFullUserProfile - Error calling GetUserByID: %v\n", err)
 		return nil, fmt.Errorf("error fetching user from GetUserByID: %v", err)
 	}
 
-	// Create a profile based on the user
-	userProfile := &models.UserProfile{
-		ID:               user.ID,
-		Name:             user.Name,
-		UniversityName:   user.UniversityName,
-		Work:             user.Work,
-		HomeTown:         user.HomeTown,
-		Height:           user.Height,
-		Age:              user.Age,
-		Zodiac:           user.Zodiac,
-		CurrentlyPlaying: user.CurrentlyPlaying,
-		LastPlayedSong:   user.LastPlayedSong,
-		UserLastActiveAt: user.UserLastActiveAt,
-		BirthdayInUnix:   user.BirthdayInUnix,
-		Gender:           user.Gender,
-		DatingPreference: user.DatingPreference,
+	if user == nil {
+		return nil, nil
 	}
 
+	// Create a profile based on the user
+	userProfile := models.NewUserProfile(user, time.Now())
+
 	fmt.Printf("[DEBUG] UserProfile created with: BirthdayInUnix=%v, Gender=%v, DatingPreference=%v\n",
 		userProfile.BirthdayInUnix, userProfile.Gender, userProfile.DatingPreference)
 
 	// Get images
-	images, err := db.GetUserImages(userID)
+	images, err := db.GetUserImages(ctx, userID)
 	if err != nil {
 		fmt.Printf("[ERROR] Error fetching images: %v\n", err)
 		return nil, fmt.Errorf("error fetching images: %v", err)
@@ -483,7 +754,7 @@ func (db *DB) GetFullUserProfile(userID uuid.UUID) (*models.UserProfile, error)
 	userProfile.Images = images
 
 	// Get interests
-	interests, err := db.GetUserInterests(userID)
+	interests, err := db.GetUserInterests(ctx, userID)
 	if err != nil {
 		fmt.Printf("[ERROR] Error fetching interests: %v\n", err)
 		return nil, fmt.Errorf("error fetching interests: %v", err)
@@ -491,7 +762,7 @@ func (db *DB) GetFullUserProfile(userID uuid.UUID) (*models.UserProfile, error)
 	userProfile.Interests = interests
 
 	// Get interest ratings
-	interestRatings, err := db.GetUserInterestRatings(userID)
+	interestRatings, err := db.GetUserInterestRatings(ctx, userID)
 	if err != nil {
 		fmt.Printf("[ERROR] Error fetching interest ratings: %v\n", err)
 		return nil, fmt.Errorf("error fetching interest ratings: %v", err)
@@ -499,7 +770,7 @@ func (db *DB) GetFullUserProfile(userID uuid.UUID) (*models.UserProfile, error)
 	userProfile.InterestRating = interestRatings
 
 	// Get prompts
-	prompts, err := db.GetUserPrompts(userID)
+	prompts, err := db.GetUserPrompts(ctx, userID)
 	if err != nil {
 		fmt.Printf("[ERROR] Error fetching prompts: %v\n", err)
 		return nil, fmt.Errorf("error fetching prompts: %v", err)
@@ -507,7 +778,7 @@ func (db *DB) GetFullUserProfile(userID uuid.UUID) (*models.UserProfile, error)
 	userProfile.Prompts = prompts
 
 	// Get top artists
-	topArtists, err := db.GetUserArtists(userID)
+	topArtists, err := db.GetUserArtists(ctx, userID)
 	if err != nil {
 		fmt.Printf("[ERROR] Error fetching top artists: %v\n", err)
 		return nil, fmt.Errorf("error fetching top artists: %v", err)
@@ -515,7 +786,7 @@ func (db *DB) GetFullUserProfile(userID uuid.UUID) (*models.UserProfile, error)
 	userProfile.TopArtists = topArtists
 
 	// Get top songs
-	topSongs, err := db.GetUserSongs(userID)
+	topSongs, err := db.GetUserSongs(ctx, userID)
 	if err != nil {
 		fmt.Printf("[ERROR] Error fetching top songs: %v\n", err)
 		return nil, fmt.Errorf("error fetching top songs: %v", err)
@@ -523,13 +794,29 @@ func (db *DB) GetFullUserProfile(userID uuid.UUID) (*models.UserProfile, error)
 	userProfile.TopSongs = topSongs
 
 	// Get saved playlists
-	savedPlaylists, err := db.GetUserPlaylists(userID)
+	savedPlaylists, err := db.GetUserPlaylists(ctx, userID)
 	if err != nil {
 		fmt.Printf("[ERROR] Error fetching saved playlists: %v\n", err)
 		return nil, fmt.Errorf("error fetching saved playlists: %v", err)
 	}
 	userProfile.SavedPlaylists = savedPlaylists
 
+	// Get genre distribution
+	genres, err := db.GetUserGenres(ctx, userID)
+	if err != nil {
+		fmt.Printf("[ERROR] Error fetching genres: %v\n", err)
+		return nil, fmt.Errorf("error fetching genres: %v", err)
+	}
+	userProfile.Genres = genres
+
+	// Get vibe vector
+	vibeVector, err := db.GetUserVibeVector(ctx, userID)
+	if err != nil {
+		fmt.Printf("[ERROR] Error fetching vibe vector: %v\n", err)
+		return nil, fmt.Errorf("error fetching vibe vector: %v", err)
+	}
+	userProfile.VibeVector = vibeVector
+
 	fmt.Printf("[DEBUG] Final userProfile: BirthdayInUnix=%v, Gender=%v, DatingPreference=%v\n",
 		userProfile.BirthdayInUnix, userProfile.Gender, userProfile.DatingPreference)
 
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/matchmyvibe/backend/internal/geo"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// DiscoveryFilter selects the users that can be shown to someone in discovery.
+// Users they already swiped on, were ever matched with or who blocked or were
+// blocked by them are always left out.
+type DiscoveryFilter struct {
+	UserID uuid.UUID
+	// Genders the user is interested in
+	Genders []string
+	// DatingPreferences that are interested in the user's gender
+	DatingPreferences []string
+	// BornAfter and BornBefore bound the birthday as Unix timestamps, inclusive
+	BornAfter  int64
+	BornBefore int64
+	// MinHeightCM and MaxHeightCM bound the height when set, users without a
+	// known height are left out then
+	MinHeightCM *int
+	MaxHeightCM *int
+	// MinSharedArtists and MinSharedGenres are how many top artists and genres
+	// candidates must have in common with the user
+	MinSharedArtists int
+	MinSharedGenres  int
+	// CountSharedArtists and CountSharedGenres fill in the candidates' shared
+	// counts, which are left zero otherwise. They are implied by the minimums.
+	CountSharedArtists bool
+	CountSharedGenres  bool
+	// Origin is the user's location that distances are measured from, nil
+	// when they have none
+	Origin *models.Location
+	// MaxDistanceKM leaves out users further from Origin and users without a
+	// location when set together with Origin
+	MaxDistanceKM *int
+	// IDs restricts the candidates to these users when set
+	IDs []uuid.UUID
+	// Limit caps how many candidates are returned, most recently active
+	// first. Zero returns all of them.
+	Limit int
+}
+
+// DiscoveryCandidate is a user matching a discovery filter, with what the
+// user's soft preferences are checked against
+type DiscoveryCandidate struct {
+	ID             uuid.UUID
+	BirthdayInUnix int64
+	HeightCM       *int
+	// SharedArtists and SharedGenres are only counted when the filter asks for them
+	SharedArtists int
+	SharedGenres  int
+	// DistanceKM is the distance from the filter's Origin, nil when either
+	// location is unknown
+	DistanceKM *float64
+}
+
+// GetDiscoveryCandidates returns the users matching the filter
+func (db *DB) GetDiscoveryCandidates(ctx context.Context, filter DiscoveryFilter) ([]DiscoveryCandidate, error) {
+	query := `SELECT id, "birthdayInUnix", height_cm, shared_artists, shared_genres, distance_km FROM (
+				 SELECT id, "birthdayInUnix", height_cm, user_last_active_at,
+				 CASE WHEN $14::DOUBLE PRECISION IS NULL OR latitude IS NULL THEN NULL
+				 ELSE 2 * 6371 * ASIN(LEAST(1, SQRT(
+					 POWER(SIN(RADIANS(latitude - $14) / 2), 2) +
+					 COS(RADIANS($14)) * COS(RADIANS(latitude)) * POWER(SIN(RADIANS(longitude - $15) / 2), 2)
+				 ))) END AS distance_km,
+				 CASE WHEN $12 THEN (SELECT COUNT(DISTINCT a.uri) FROM artists a
+				  WHERE a.user_id = users.id AND a.uri IN (SELECT uri FROM artists WHERE user_id = $1)) ELSE 0 END AS shared_artists,
+				 CASE WHEN $13 THEN (SELECT COUNT(*) FROM user_genres g
+				  WHERE g.user_id = users.id AND g.genre IN (SELECT genre FROM user_genres WHERE user_id = $1)) ELSE 0 END AS shared_genres
+				 FROM users
+				 WHERE id <> $1
+				 AND gender = ANY($2) AND dating_preference = ANY($3)
+				 AND "birthdayInUnix" BETWEEN $4 AND $5
+				 AND ($6::INTEGER IS NULL OR height_cm >= $6)
+				 AND ($7::INTEGER IS NULL OR height_cm <= $7)
+				 AND ($16::INTEGER IS NULL OR (latitude BETWEEN $17 AND $18 AND longitude BETWEEN $19 AND $20))
+				 AND ($11::UUID[] IS NULL OR id = ANY($11))
+				 AND NOT EXISTS (SELECT 1 FROM swipes s WHERE s.actor_id = $1 AND s.target_id = users.id)
+				 AND NOT EXISTS (SELECT 1 FROM matches m WHERE (m.user_a = $1 AND m.user_b = users.id) OR (m.user_b = $1 AND m.user_a = users.id))
+				 AND NOT EXISTS (SELECT 1 FROM blocks b WHERE (b.blocker_id = $1 AND b.blocked_id = users.id) OR (b.blocker_id = users.id AND b.blocked_id = $1))
+			 ) candidates
+			 WHERE shared_artists >= $8 AND shared_genres >= $9
+			 AND ($16::INTEGER IS NULL OR distance_km <= $16)
+			 ORDER BY user_last_active_at DESC NULLS LAST, id
+			 LIMIT NULLIF($10, 0)`
+
+	// The bounding box lets Postgres use the location index before computing
+	// exact distances
+	var originLat, originLng *float64
+	var maxDistance *int
+	var box geo.Box
+	if filter.Origin != nil {
+		originLat, originLng = &filter.Origin.Latitude, &filter.Origin.Longitude
+		if filter.MaxDistanceKM != nil {
+			maxDistance = filter.MaxDistanceKM
+			box = geo.BoundingBox(filter.Origin.Latitude, filter.Origin.Longitude, float64(*maxDistance))
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, query,
+		filter.UserID, pq.Array(filter.Genders), pq.Array(filter.DatingPreferences),
+		filter.BornAfter, filter.BornBefore, filter.MinHeightCM, filter.MaxHeightCM,
+		filter.MinSharedArtists, filter.MinSharedGenres, filter.Limit, pq.Array(filter.IDs),
+		filter.CountSharedArtists || filter.MinSharedArtists > 0, filter.CountSharedGenres || filter.MinSharedGenres > 0,
+		originLat, originLng, maxDistance, box.MinLat, box.MaxLat, box.MinLng, box.MaxLng,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []DiscoveryCandidate
+	for rows.Next() {
+		var candidate DiscoveryCandidate
+		if err := rows.Scan(&candidate.ID, &candidate.BirthdayInUnix, &candidate.HeightCM,
+			&candidate.SharedArtists, &candidate.SharedGenres, &candidate.DistanceKM); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
+}
+
+// DiscoverySession is a snapshot of someone's discovery candidates, so paging
+// through the feed neither repeats nor skips anyone while their activity or
+// compatibility changes
+type DiscoverySession struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// CandidateIDs are every candidate when the session started, most
+	// recently active first
+	CandidateIDs []uuid.UUID
+	// RankedIDs is the feed order of the candidates ranked so far
+	RankedIDs []uuid.UUID
+	// RankedThrough is how many of CandidateIDs were ranked into RankedIDs
+	RankedThrough int
+	CreatedAt     time.Time
+}
+
+// CreateDiscoverySession starts a discovery session over the candidates and
+// deletes the user's sessions created before expiredBefore
+func (db *DB) CreateDiscoverySession(ctx context.Context, userID uuid.UUID, candidateIDs []uuid.UUID, expiredBefore time.Time) (*DiscoverySession, error) {
+	if _, err := db.ExecContext(ctx, `DELETE FROM discovery_sessions WHERE user_id = $1 AND created_at < $2`, userID, expiredBefore); err != nil {
+		return nil, err
+	}
+
+	// An empty array, as the column can't be NULL
+	if candidateIDs == nil {
+		candidateIDs = []uuid.UUID{}
+	}
+
+	session := &DiscoverySession{
+		ID:           uuid.New(),
+		UserID:       userID,
+		CandidateIDs: candidateIDs,
+		CreatedAt:    time.Now(),
+	}
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO discovery_sessions (id, user_id, candidate_ids, created_at) VALUES ($1, $2, $3, $4)`,
+		session.ID, userID, pq.Array(candidateIDs), session.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetDiscoverySession returns one of the user's discovery sessions
+func (db *DB) GetDiscoverySession(ctx context.Context, id, userID uuid.UUID) (*DiscoverySession, error) {
+	session := &DiscoverySession{}
+	err := db.QueryRowContext(ctx,
+		`SELECT id, user_id, candidate_ids, ranked_ids, ranked_through, created_at
+		 FROM discovery_sessions WHERE id = $1 AND user_id = $2`,
+		id, userID,
+	).Scan(&session.ID, &session.UserID, pq.Array(&session.CandidateIDs), pq.Array(&session.RankedIDs),
+		&session.RankedThrough, &session.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// AppendDiscoveryRanking adds the ranking of the candidates from
+// session.RankedThrough up to rankedThrough to the session. It reports false
+// without changing anything when another request ranked them first.
+func (db *DB) AppendDiscoveryRanking(ctx context.Context, session *DiscoverySession, rankedIDs []uuid.UUID, rankedThrough int) (bool, error) {
+	result, err := db.ExecContext(ctx,
+		`UPDATE discovery_sessions SET ranked_ids = ranked_ids || $2::UUID[], ranked_through = $3
+		 WHERE id = $1 AND ranked_through = $4`,
+		session.ID, pq.Array(rankedIDs), rankedThrough, session.RankedThrough,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	session.RankedIDs = append(session.RankedIDs, rankedIDs...)
+	session.RankedThrough = rankedThrough
+	return true, nil
+}
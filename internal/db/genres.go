@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// CatalogArtist is an artist's Spotify metadata shared by every user
+type CatalogArtist struct {
+	URI        string
+	Name       string
+	Popularity int
+	Genres     []string
+}
+
+// SaveCatalogArtists upserts artists and replaces their genres
+func (db *DB) SaveCatalogArtists(ctx context.Context, artists []CatalogArtist) error {
+	// Concurrent syncs share artists, locking their rows in the same order
+	// keeps them from deadlocking
+	artists = append([]CatalogArtist(nil), artists...)
+	sort.Slice(artists, func(i, j int) bool { return artists[i].URI < artists[j].URI })
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, artist := range artists {
+		genres := make([]string, len(artist.Genres))
+		copy(genres, artist.Genres)
+		sort.Strings(genres)
+
+		query := `INSERT INTO spotify_artists (uri, name, popularity, updated_at) VALUES ($1, $2, $3, NOW())
+				 ON CONFLICT (uri) DO UPDATE SET name = $2, popularity = $3, updated_at = NOW()`
+		if _, err := tx.ExecContext(ctx, query, artist.URI, artist.Name, artist.Popularity); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM artist_genres WHERE artist_uri = $1 AND NOT (genre = ANY($2))`, artist.URI, pq.Array(genres)); err != nil {
+			return err
+		}
+		for _, genre := range genres {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO artist_genres (artist_uri, genre) VALUES ($1, $2) ON CONFLICT DO NOTHING`, artist.URI, genre); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// replaceUserGenres replaces a user's genre distribution
+func replaceUserGenres(ctx context.Context, ex execer, userID uuid.UUID, genres []models.GenreShare) error {
+	if _, err := ex.ExecContext(ctx, `DELETE FROM user_genres WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, genre := range genres {
+		if _, err := ex.ExecContext(ctx, `INSERT INTO user_genres (user_id, genre, share) VALUES ($1, $2, $3)`, userID, genre.Genre, genre.Share); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserGenres retrieves a user's genre distribution, largest share first
+func (db *DB) GetUserGenres(ctx context.Context, userID uuid.UUID) ([]models.GenreShare, error) {
+	rows, err := db.QueryContext(ctx, `SELECT genre, share FROM user_genres WHERE user_id = $1 ORDER BY share DESC, genre`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []models.GenreShare{}
+	for rows.Next() {
+		var genre models.GenreShare
+		if err := rows.Scan(&genre.Genre, &genre.Share); err != nil {
+			return nil, err
+		}
+		genres = append(genres, genre)
+	}
+
+	return genres, rows.Err()
+}
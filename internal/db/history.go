@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// HistoryFilter narrows down a listening history query. Zero values are ignored.
+type HistoryFilter struct {
+	From time.Time
+	To   time.Time
+
+	// BeforePlayedAt and BeforeID continue a previous page, newest first
+	BeforePlayedAt time.Time
+	BeforeID       uuid.UUID
+
+	Limit int
+}
+
+// GetHistoryCursor returns the recently played cursor stored for a user, or
+// zero if their history was never ingested
+func (db *DB) GetHistoryCursor(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var afterMS int64
+	err := db.QueryRowContext(ctx, `SELECT after_ms FROM listening_history_cursors WHERE user_id = $1`, userID).Scan(&afterMS)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return afterMS, nil
+}
+
+// SaveListeningHistory stores newly ingested plays and advances the user's
+// cursor in one transaction. Plays that were already stored are skipped. It
+// returns how many plays were inserted.
+func (db *DB) SaveListeningHistory(ctx context.Context, userID uuid.UUID, entries []models.ListeningHistoryEntry, afterMS int64) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO listening_history (id, user_id, track_uri, track_name, artist, artist_uris, album, album_uri,
+			 image_url, duration_ms, context_uri, context_type, played_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			 ON CONFLICT (user_id, played_at, track_uri) DO NOTHING`
+
+	inserted := 0
+	for _, entry := range entries {
+		result, err := tx.ExecContext(ctx, query,
+			uuid.New(), userID, entry.TrackURI, entry.TrackName, entry.Artist, pq.Array(entry.ArtistURIs), entry.Album, entry.AlbumURI,
+			entry.ImageURL, entry.DurationMS, entry.ContextURI, entry.ContextType, entry.PlayedAt,
+		)
+		if err != nil {
+			return 0, err
+		}
+		rows, _ := result.RowsAffected()
+		inserted += int(rows)
+	}
+
+	// Never move the cursor backwards if two ingestions overlap
+	cursorQuery := `INSERT INTO listening_history_cursors (user_id, after_ms, synced_at) VALUES ($1, $2, NOW())
+			 ON CONFLICT (user_id) DO UPDATE SET after_ms = GREATEST(listening_history_cursors.after_ms, $2), synced_at = NOW(),
+			 failures = 0, retry_at = NULL`
+	if _, err := tx.ExecContext(ctx, cursorQuery, userID, afterMS); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// GetUsersDueForHistoryIngest retrieves users whose history was not ingested
+// since the given time, least recently ingested first. Users that must log in
+// again or whose last ingestion failed recently are skipped.
+func (db *DB) GetUsersDueForHistoryIngest(ctx context.Context, syncedBefore time.Time, limit int) ([]models.User, error) {
+	query := `SELECT ` + prefixColumns("u", userColumns) + ` FROM users u
+			 LEFT JOIN listening_history_cursors c ON c.user_id = u.id
+			 WHERE NOT u.needs_reauth AND (c.synced_at IS NULL OR c.synced_at < $1)
+			 AND (c.retry_at IS NULL OR c.retry_at <= NOW())
+			 ORDER BY c.synced_at NULLS FIRST LIMIT $2`
+
+	return db.queryUsers(ctx, query, syncedBefore, limit)
+}
+
+// RecordHistoryIngestFailure backs off ingesting a user's history after a
+// failed ingestion. The delay starts at backoff and doubles with every further
+// failure, up to maxBackoff. The cursor itself is left untouched.
+func (db *DB) RecordHistoryIngestFailure(ctx context.Context, userID uuid.UUID, backoff, maxBackoff time.Duration) error {
+	query := `INSERT INTO listening_history_cursors (user_id, synced_at, failures, retry_at)
+			 VALUES ($1, 'epoch', 1, NOW() + LEAST($2, $3) * INTERVAL '1 second')
+			 ON CONFLICT (user_id) DO UPDATE SET failures = listening_history_cursors.failures + 1,
+			 retry_at = NOW() + LEAST($2 * POWER(2, LEAST(listening_history_cursors.failures, 30)), $3) * INTERVAL '1 second'`
+	_, err := db.ExecContext(ctx, query, userID, backoff.Seconds(), maxBackoff.Seconds())
+	return err
+}
+
+// GetListeningHistory retrieves a user's plays, newest first
+func (db *DB) GetListeningHistory(ctx context.Context, userID uuid.UUID, filter HistoryFilter) ([]models.ListeningHistoryEntry, error) {
+	query := `SELECT id, track_uri, track_name, artist, artist_uris, album, album_uri, image_url,
+			 duration_ms, context_uri, context_type, played_at
+			 FROM listening_history
+			 WHERE user_id = $1
+			 AND ($2::timestamp IS NULL OR played_at >= $2)
+			 AND ($3::timestamp IS NULL OR played_at < $3)
+			 AND ($4::timestamp IS NULL OR (played_at, id) < ($4, $5))
+			 ORDER BY played_at DESC, id DESC
+			 LIMIT $6`
+
+	rows, err := db.QueryContext(ctx, query, userID,
+		nullTime(filter.From), nullTime(filter.To), nullTime(filter.BeforePlayedAt), filter.BeforeID, filter.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.ListeningHistoryEntry{}
+	for rows.Next() {
+		var entry models.ListeningHistoryEntry
+		if err := rows.Scan(
+			&entry.ID, &entry.TrackURI, &entry.TrackName, &entry.Artist, pq.Array(&entry.ArtistURIs), &entry.Album, &entry.AlbumURI, &entry.ImageURL,
+			&entry.DurationMS, &entry.ContextURI, &entry.ContextType, &entry.PlayedAt,
+		); err != nil {
+			return nil, err
+		}
+		entry.UserID = userID
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// nullTime maps the zero time to NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
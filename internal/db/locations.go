@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// nullLocation scans the location columns of a user, which are all null
+// until they share their location
+type nullLocation struct {
+	Latitude  *float64
+	Longitude *float64
+	Geohash   *string
+	UpdatedAt *time.Time
+}
+
+// location returns the scanned location, nil if the user has none
+func (l nullLocation) location() *models.Location {
+	if l.Latitude == nil || l.Longitude == nil {
+		return nil
+	}
+
+	location := &models.Location{Latitude: *l.Latitude, Longitude: *l.Longitude}
+	if l.Geohash != nil {
+		location.Geohash = *l.Geohash
+	}
+	if l.UpdatedAt != nil {
+		location.UpdatedAt = *l.UpdatedAt
+	}
+	return location
+}
+
+// GetUserLocation retrieves a user's location, nil if they have none
+func (db *DB) GetUserLocation(ctx context.Context, userID uuid.UUID) (*models.Location, error) {
+	query := `SELECT latitude, longitude, geohash, location_updated_at FROM users WHERE id = $1`
+
+	var location nullLocation
+	err := db.QueryRowContext(ctx, query, userID).Scan(
+		&location.Latitude, &location.Longitude, &location.Geohash, &location.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return location.location(), nil
+}
+
+// UpdateUserLocation stores a user's location and fills in its UpdatedAt. The
+// location must already be rounded. It reports false, and stores nothing, when
+// the user's location was already updated within minInterval.
+func (db *DB) UpdateUserLocation(ctx context.Context, userID uuid.UUID, location *models.Location, minInterval time.Duration) (bool, error) {
+	query := `UPDATE users SET latitude = $1, longitude = $2, geohash = $3, location_updated_at = NOW()
+			 WHERE id = $4
+			 AND (location_updated_at IS NULL OR location_updated_at <= NOW() - $5 * INTERVAL '1 millisecond')
+			 RETURNING location_updated_at`
+
+	err := db.QueryRowContext(ctx, query,
+		location.Latitude, location.Longitude, location.Geohash, userID, minInterval.Milliseconds(),
+	).Scan(&location.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// matchColumns lists the matches columns read by scanMatch, in scan order
+const matchColumns = `id, user_a, user_b, created_at, unmatched_at`
+
+func scanMatch(row rowScanner) (*models.Match, error) {
+	var match models.Match
+	if err := row.Scan(&match.ID, &match.UserA, &match.UserB, &match.CreatedAt, &match.UnmatchedAt); err != nil {
+		return nil, err
+	}
+	return &match, nil
+}
+
+// GetMatch retrieves a match by its ID, including unmatched ones
+func (db *DB) GetMatch(ctx context.Context, matchID uuid.UUID) (*models.Match, error) {
+	query := `SELECT ` + matchColumns + ` FROM matches WHERE id = $1`
+
+	match, err := scanMatch(db.QueryRowContext(ctx, query, matchID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return match, nil
+}
+
+// GetUserMatches retrieves a user's active matches, newest first. A non-zero
+// beforeCreatedAt and beforeID continue a previous page.
+func (db *DB) GetUserMatches(ctx context.Context, userID uuid.UUID, beforeCreatedAt time.Time, beforeID uuid.UUID, limit int) ([]models.Match, error) {
+	query := `SELECT ` + matchColumns + ` FROM matches
+			 WHERE (user_a = $1 OR user_b = $1) AND unmatched_at IS NULL
+			 AND ($2::timestamp IS NULL OR (created_at, id) < ($2, $3))
+			 ORDER BY created_at DESC, id DESC
+			 LIMIT $4`
+
+	rows, err := db.QueryContext(ctx, query, userID, nullTime(beforeCreatedAt), beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []models.Match{}
+	for rows.Next() {
+		match, err := scanMatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, *match)
+	}
+
+	return matches, rows.Err()
+}
+
+// GetActiveMatches retrieves all of a user's active matches
+func (db *DB) GetActiveMatches(ctx context.Context, userID uuid.UUID) ([]models.Match, error) {
+	query := `SELECT ` + matchColumns + ` FROM matches
+			 WHERE (user_a = $1 OR user_b = $1) AND unmatched_at IS NULL`
+
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []models.Match{}
+	for rows.Next() {
+		match, err := scanMatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, *match)
+	}
+
+	return matches, rows.Err()
+}
+
+// Unmatch ends an active match on behalf of one of its users. It reports
+// whether the match was active.
+func (db *DB) Unmatch(ctx context.Context, matchID, userID uuid.UUID) (bool, error) {
+	query := `UPDATE matches SET unmatched_at = NOW(), unmatched_by = $2
+			 WHERE id = $1 AND (user_a = $2 OR user_b = $2) AND unmatched_at IS NULL`
+
+	result, err := db.ExecContext(ctx, query, matchID, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// messageColumns lists the messages columns read by scanMessage, in scan order
+const messageColumns = `id, match_id, sender_id, seq, type, payload, created_at`
+
+func scanMessage(row rowScanner) (*models.Message, error) {
+	var message models.Message
+	var payload []byte
+	if err := row.Scan(&message.ID, &message.MatchID, &message.SenderID, &message.Seq, &message.Type, &payload, &message.CreatedAt); err != nil {
+		return nil, err
+	}
+	message.Payload = payload
+	return &message, nil
+}
+
+// SendMessage stores a message from one of the users of an active match. The
+// match row is locked while the next sequence number is taken, so messages
+// sent at the same time are ordered. It returns nil if the match is not active
+// or the sender is not in it.
+func (db *DB) SendMessage(ctx context.Context, matchID, senderID uuid.UUID, messageType string, payload []byte) (*models.Message, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	err = tx.QueryRowContext(ctx, `UPDATE matches SET last_message_seq = last_message_seq + 1
+			 WHERE id = $1 AND (user_a = $2 OR user_b = $2) AND unmatched_at IS NULL
+			 RETURNING last_message_seq`, matchID, senderID).Scan(&seq)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	query := `INSERT INTO messages (id, match_id, sender_id, seq, type, payload, created_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, NOW()) RETURNING ` + messageColumns
+	message, err := scanMessage(tx.QueryRowContext(ctx, query, uuid.New(), matchID, senderID, seq, messageType, payload))
+	if err != nil {
+		return nil, err
+	}
+
+	// Sending a message means the sender has read everything before it
+	if err := markRead(ctx, tx, matchID, senderID, seq); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// GetMessages retrieves a match's messages, newest first. A non-zero beforeSeq
+// continues a previous page.
+func (db *DB) GetMessages(ctx context.Context, matchID uuid.UUID, beforeSeq int64, limit int) ([]models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages
+			 WHERE match_id = $1 AND ($2 = 0 OR seq < $2)
+			 ORDER BY seq DESC
+			 LIMIT $3`
+
+	rows, err := db.QueryContext(ctx, query, matchID, beforeSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *message)
+	}
+
+	return messages, rows.Err()
+}
+
+// MarkRead records that a user has read a match's messages up to seq. The read
+// position never moves backwards or past the last message.
+func (db *DB) MarkRead(ctx context.Context, matchID, userID uuid.UUID, seq int64) error {
+	return markRead(ctx, db.DB, matchID, userID, seq)
+}
+
+func markRead(ctx context.Context, ex execer, matchID, userID uuid.UUID, seq int64) error {
+	query := `INSERT INTO match_reads (match_id, user_id, last_read_seq)
+			 SELECT id, $2, LEAST($3, last_message_seq) FROM matches WHERE id = $1
+			 ON CONFLICT (match_id, user_id) DO UPDATE SET last_read_seq = GREATEST(match_reads.last_read_seq, EXCLUDED.last_read_seq)`
+	_, err := ex.ExecContext(ctx, query, matchID, userID, seq)
+	return err
+}
+
+// GetMatchSummaries retrieves the last message and the user's unread count for
+// each of the given matches
+func (db *DB) GetMatchSummaries(ctx context.Context, userID uuid.UUID, matchIDs []uuid.UUID) (map[uuid.UUID]*models.MatchSummary, error) {
+	summaries := make(map[uuid.UUID]*models.MatchSummary, len(matchIDs))
+
+	query := `SELECT m.id, m.last_message_seq - COALESCE(r.last_read_seq, 0)
+			 FROM matches m
+			 LEFT JOIN match_reads r ON r.match_id = m.id AND r.user_id = $2
+			 WHERE m.id = ANY($1)`
+	rows, err := db.QueryContext(ctx, query, pq.Array(matchIDs), userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var matchID uuid.UUID
+		summary := &models.MatchSummary{}
+		if err := rows.Scan(&matchID, &summary.UnreadCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		summaries[matchID] = summary
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `SELECT DISTINCT ON (match_id) ` + messageColumns + ` FROM messages
+			 WHERE match_id = ANY($1)
+			 ORDER BY match_id, seq DESC`
+	rows, err = db.QueryContext(ctx, query, pq.Array(matchIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		if summary, ok := summaries[message.MatchID]; ok {
+			summary.LastMessage = message
+		}
+	}
+
+	return summaries, rows.Err()
+}
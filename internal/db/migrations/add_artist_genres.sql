@@ -0,0 +1,26 @@
+-- Spotify metadata for every artist a user listens to
+CREATE TABLE IF NOT EXISTS spotify_artists (
+    uri TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    popularity INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Genres Spotify assigns to each artist
+CREATE TABLE IF NOT EXISTS artist_genres (
+    artist_uri TEXT NOT NULL REFERENCES spotify_artists(uri) ON DELETE CASCADE,
+    genre TEXT NOT NULL,
+    PRIMARY KEY (artist_uri, genre)
+);
+
+-- Each user's genre distribution, recomputed on every top items sync
+CREATE TABLE IF NOT EXISTS user_genres (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    genre TEXT NOT NULL,
+    share DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (user_id, genre)
+);
+
+CREATE INDEX IF NOT EXISTS idx_artist_genres_genre ON artist_genres(genre);
+
+COMMENT ON COLUMN user_genres.share IS 'Rank weighted share of the user''s top artists and songs in this genre, between 0 and 1';
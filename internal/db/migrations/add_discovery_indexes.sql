@@ -0,0 +1,3 @@
+-- Speed up the discovery candidate query
+CREATE INDEX IF NOT EXISTS idx_users_discovery ON users(gender, dating_preference, "birthdayInUnix");
+CREATE INDEX IF NOT EXISTS idx_users_user_last_active_at ON users(user_last_active_at DESC NULLS LAST);
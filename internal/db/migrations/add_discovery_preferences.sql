@@ -0,0 +1,37 @@
+-- Heights in centimeters, read from the free text height so discovery can
+-- filter on them
+ALTER TABLE users ADD COLUMN IF NOT EXISTS height_cm INTEGER;
+
+-- Reads heights the same way as models.ParseHeightCM
+UPDATE users SET height_cm = CASE
+    WHEN lower(height) ~ '^\s*\d{2,3}(\.\d+)?\s*(cm)?\s*$'
+        THEN ROUND(substring(height FROM '\d{2,3}(?:\.\d+)?')::NUMERIC)
+    WHEN lower(height) ~ '^\s*[12]\.\d{1,2}\s*m\s*$'
+        THEN ROUND(substring(height FROM '[12]\.\d{1,2}')::NUMERIC * 100)
+    WHEN lower(height) ~ '^\s*\d\s*(''|’|ft|feet|foot)\s*(\d{1,2}\s*("|”|''''|in|inches)?)?\s*$'
+        AND COALESCE(substring(lower(height) FROM '^\s*\d\s*(?:''|’|ft|feet|foot)\s*(\d{1,2})')::INTEGER, 0) < 12
+        THEN ROUND(substring(height FROM '^\s*(\d)')::NUMERIC * 30.48
+            + COALESCE(substring(lower(height) FROM '^\s*\d\s*(?:''|’|ft|feet|foot)\s*(\d{1,2})')::NUMERIC, 0) * 2.54)
+END
+WHERE height IS NOT NULL AND height_cm IS NULL;
+
+UPDATE users SET height_cm = NULL WHERE height_cm NOT BETWEEN 100 AND 250;
+
+-- Filters each user applies to discovery
+CREATE TABLE IF NOT EXISTS discovery_preferences (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    min_age INTEGER CHECK (min_age BETWEEN 18 AND 100),
+    max_age INTEGER CHECK (max_age BETWEEN 18 AND 100),
+    max_distance_km INTEGER CHECK (max_distance_km BETWEEN 1 AND 500),
+    min_height_cm INTEGER CHECK (min_height_cm BETWEEN 100 AND 250),
+    max_height_cm INTEGER CHECK (max_height_cm BETWEEN 100 AND 250),
+    min_shared_genres INTEGER NOT NULL DEFAULT 0 CHECK (min_shared_genres BETWEEN 0 AND 25),
+    min_shared_artists INTEGER NOT NULL DEFAULT 0 CHECK (min_shared_artists BETWEEN 0 AND 50),
+    dealbreakers TEXT[] NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_height_cm ON users(height_cm);
+CREATE INDEX IF NOT EXISTS idx_user_genres_genre ON user_genres(genre);
+
+COMMENT ON COLUMN discovery_preferences.dealbreakers IS 'preferences that are hard filters, the others only rank candidates who miss them lower';
@@ -0,0 +1,16 @@
+-- Snapshot the discovery candidates of each feed session so paging through it
+-- neither repeats nor skips anyone when activity or scores change
+CREATE TABLE IF NOT EXISTS discovery_sessions (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    candidate_ids UUID[] NOT NULL,
+    ranked_ids UUID[] NOT NULL DEFAULT '{}',
+    ranked_through INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_discovery_sessions_user_id_created_at ON discovery_sessions(user_id, created_at);
+
+COMMENT ON COLUMN discovery_sessions.candidate_ids IS 'Every candidate when the session started, most recently active first';
+COMMENT ON COLUMN discovery_sessions.ranked_ids IS 'The feed order of the candidates ranked so far, one pool after the other';
+COMMENT ON COLUMN discovery_sessions.ranked_through IS 'How many of candidate_ids were ranked into ranked_ids';
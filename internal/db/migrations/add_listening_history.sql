@@ -0,0 +1,32 @@
+-- Store every play ingested from the Spotify recently played endpoint
+CREATE TABLE IF NOT EXISTS listening_history (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    track_uri TEXT NOT NULL,
+    track_name TEXT NOT NULL,
+    artist TEXT NOT NULL,
+    artist_uris TEXT[] NOT NULL DEFAULT '{}',
+    album TEXT NOT NULL,
+    album_uri TEXT NOT NULL,
+    image_url TEXT,
+    duration_ms INTEGER NOT NULL,
+    context_uri TEXT,
+    context_type TEXT,
+    played_at TIMESTAMP NOT NULL,
+    UNIQUE (user_id, played_at, track_uri)
+);
+
+-- Remember where ingestion left off for each user
+CREATE TABLE IF NOT EXISTS listening_history_cursors (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    after_ms BIGINT NOT NULL DEFAULT 0,
+    synced_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    failures INTEGER NOT NULL DEFAULT 0,
+    retry_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_listening_history_user_id_played_at ON listening_history(user_id, played_at DESC, id DESC);
+
+COMMENT ON COLUMN listening_history_cursors.after_ms IS 'Spotify recently played "after" cursor as Unix milliseconds';
+COMMENT ON COLUMN listening_history_cursors.failures IS 'Consecutive failed ingestions, reset by a successful one';
+COMMENT ON COLUMN listening_history_cursors.retry_at IS 'The history ingester skips the user until this time';
@@ -0,0 +1,11 @@
+-- Where users are, rounded to about ten kilometers before it is stored
+ALTER TABLE users ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION CHECK (latitude BETWEEN -90 AND 90);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION CHECK (longitude BETWEEN -180 AND 180);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS geohash TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS location_updated_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_users_latitude_longitude ON users(latitude, longitude);
+
+COMMENT ON COLUMN users.latitude IS 'rounded to 1 decimal, never the precise location';
+COMMENT ON COLUMN users.longitude IS 'rounded to 1 decimal, never the precise location';
+COMMENT ON COLUMN users.geohash IS '5 characters, a cell of about 5 km';
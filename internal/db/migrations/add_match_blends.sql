@@ -0,0 +1,13 @@
+-- Spotify playlists blended from both users' top songs, one per match and user
+CREATE TABLE IF NOT EXISTS match_blends (
+    match_id UUID NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    playlist_id TEXT NOT NULL,
+    playlist_uri TEXT NOT NULL,
+    track_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (match_id, user_id)
+);
+
+COMMENT ON TABLE match_blends IS 'The playlist lives in the Spotify account of user_id, it is refreshed in place when they blend again';
@@ -0,0 +1,25 @@
+-- Number the messages of each match
+ALTER TABLE matches ADD COLUMN IF NOT EXISTS last_message_seq BIGINT NOT NULL DEFAULT 0;
+
+-- Chat messages between the two users of a match
+CREATE TABLE IF NOT EXISTS messages (
+    id UUID PRIMARY KEY,
+    match_id UUID NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+    sender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (match_id, seq)
+);
+
+-- How far each user has read each conversation
+CREATE TABLE IF NOT EXISTS match_reads (
+    match_id UUID NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    last_read_seq BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (match_id, user_id)
+);
+
+COMMENT ON COLUMN messages.seq IS 'Position of the message in its match, assigned from matches.last_message_seq';
+COMMENT ON COLUMN messages.payload IS 'Type specific content, {"text": "..."} for text messages';
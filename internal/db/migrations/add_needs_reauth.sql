@@ -0,0 +1,13 @@
+-- Flag users whose Spotify refresh token was revoked
+ALTER TABLE users ADD COLUMN IF NOT EXISTS needs_reauth BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Back off users whose Spotify token refresh keeps failing
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_refresh_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_refresh_retry_at TIMESTAMP;
+
+-- The token manager scans for tokens that are about to expire
+CREATE INDEX IF NOT EXISTS idx_users_token_expiry ON users(token_expiry) WHERE NOT needs_reauth;
+
+COMMENT ON COLUMN users.needs_reauth IS 'Spotify answered invalid_grant, the user must log in again';
+COMMENT ON COLUMN users.token_refresh_failures IS 'Consecutive failed token refreshes, reset by a successful refresh';
+COMMENT ON COLUMN users.token_refresh_retry_at IS 'The token manager skips the user until this time';
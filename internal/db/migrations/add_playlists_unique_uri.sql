@@ -0,0 +1,9 @@
+-- Remove duplicate playlists left behind by earlier imports, keeping one row per URI
+DELETE FROM playlists a
+    USING playlists b
+    WHERE a.user_id = b.user_id
+      AND a.uri = b.uri
+      AND a.id > b.id;
+
+-- A user can only save each playlist once
+CREATE UNIQUE INDEX IF NOT EXISTS idx_playlists_user_id_uri ON playlists(user_id, uri);
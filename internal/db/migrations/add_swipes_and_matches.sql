@@ -0,0 +1,22 @@
+-- One like or pass per actor and target
+CREATE TABLE IF NOT EXISTS swipes (
+    actor_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    action TEXT NOT NULL CHECK (action IN ('like', 'pass')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (actor_id, target_id),
+    CHECK (actor_id <> target_id)
+);
+
+-- Pairs of users who liked each other, stored once with the smaller id first
+CREATE TABLE IF NOT EXISTS matches (
+    id UUID PRIMARY KEY,
+    user_a UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_b UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (user_a, user_b),
+    CHECK (user_a < user_b)
+);
+
+CREATE INDEX IF NOT EXISTS idx_swipes_target_id ON swipes(target_id);
+CREATE INDEX IF NOT EXISTS idx_matches_user_b ON matches(user_b);
@@ -0,0 +1,10 @@
+-- Keep the order Spotify returns top artists and songs in
+ALTER TABLE artists ADD COLUMN IF NOT EXISTS rank INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS rank INTEGER NOT NULL DEFAULT 0;
+
+-- Track when a user's top items were last synced from Spotify
+ALTER TABLE users ADD COLUMN IF NOT EXISTS top_items_synced_at TIMESTAMP;
+
+COMMENT ON COLUMN artists.rank IS 'Position in the user''s Spotify top artists, starting at 1';
+COMMENT ON COLUMN songs.rank IS 'Position in the user''s Spotify top tracks, starting at 1';
+COMMENT ON COLUMN users.top_items_synced_at IS 'Last time top artists and songs were synced from Spotify';
@@ -0,0 +1,5 @@
+-- Keep unmatched pairs so they never match or show up in discovery again
+ALTER TABLE matches ADD COLUMN IF NOT EXISTS unmatched_at TIMESTAMP;
+ALTER TABLE matches ADD COLUMN IF NOT EXISTS unmatched_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+COMMENT ON COLUMN matches.unmatched_at IS 'When either user unmatched, the conversation is hidden for both from then on';
@@ -0,0 +1,4 @@
+-- Summarize the audio features of each user's top songs
+ALTER TABLE users ADD COLUMN IF NOT EXISTS vibe_vector JSONB;
+
+COMMENT ON COLUMN users.vibe_vector IS 'Rank weighted average of the audio features of the user''s top songs';
@@ -0,0 +1,9 @@
+-- When each match was last told its users are listening to the same thing
+CREATE TABLE IF NOT EXISTS vibing_notifications (
+    match_id UUID PRIMARY KEY REFERENCES matches(id) ON DELETE CASCADE,
+    level TEXT NOT NULL,
+    uri TEXT NOT NULL,
+    notified_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON COLUMN vibing_notifications.level IS 'What the users were both listening to: track, album or artist';
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// GetDiscoveryPreferences retrieves the user's discovery preferences, nil if
+// they never saved any
+func (db *DB) GetDiscoveryPreferences(ctx context.Context, userID uuid.UUID) (*models.DiscoveryPreferences, error) {
+	query := `SELECT min_age, max_age, max_distance_km, min_height_cm, max_height_cm,
+			 min_shared_genres, min_shared_artists, dealbreakers, updated_at
+			 FROM discovery_preferences WHERE user_id = $1`
+
+	var prefs models.DiscoveryPreferences
+	err := db.QueryRowContext(ctx, query, userID).Scan(
+		&prefs.MinAge, &prefs.MaxAge, &prefs.MaxDistanceKM, &prefs.MinHeightCM, &prefs.MaxHeightCM,
+		&prefs.MinSharedGenres, &prefs.MinSharedArtists, pq.Array(&prefs.Dealbreakers), &prefs.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if prefs.Dealbreakers == nil {
+		prefs.Dealbreakers = []string{}
+	}
+	return &prefs, nil
+}
+
+// SaveDiscoveryPreferences replaces the user's discovery preferences and
+// fills in UpdatedAt
+func (db *DB) SaveDiscoveryPreferences(ctx context.Context, userID uuid.UUID, prefs *models.DiscoveryPreferences) error {
+	query := `INSERT INTO discovery_preferences (user_id, min_age, max_age, max_distance_km, min_height_cm, max_height_cm,
+			 min_shared_genres, min_shared_artists, dealbreakers, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+			 ON CONFLICT (user_id) DO UPDATE
+			 SET min_age = EXCLUDED.min_age, max_age = EXCLUDED.max_age, max_distance_km = EXCLUDED.max_distance_km,
+				 min_height_cm = EXCLUDED.min_height_cm, max_height_cm = EXCLUDED.max_height_cm,
+				 min_shared_genres = EXCLUDED.min_shared_genres, min_shared_artists = EXCLUDED.min_shared_artists,
+				 dealbreakers = EXCLUDED.dealbreakers, updated_at = NOW()
+			 RETURNING updated_at`
+
+	if prefs.Dealbreakers == nil {
+		prefs.Dealbreakers = []string{}
+	}
+
+	return db.QueryRowContext(ctx, query,
+		userID, prefs.MinAge, prefs.MaxAge, prefs.MaxDistanceKM, prefs.MinHeightCM, prefs.MaxHeightCM,
+		prefs.MinSharedGenres, prefs.MinSharedArtists, pq.Array(prefs.Dealbreakers),
+	).Scan(&prefs.UpdatedAt)
+}
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// queryByUser runs a query that takes an array of user ids and selects the
+// user id first, and groups the rows scanned by scan by user
+func queryByUser[T any](ctx context.Context, db *DB, query string, userIDs []uuid.UUID, scan func(rows *sql.Rows, userID *uuid.UUID) (T, error)) (map[uuid.UUID][]T, error) {
+	rows, err := db.QueryContext(ctx, query, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byUser := make(map[uuid.UUID][]T, len(userIDs))
+	for rows.Next() {
+		var userID uuid.UUID
+		item, err := scan(rows, &userID)
+		if err != nil {
+			return nil, err
+		}
+		byUser[userID] = append(byUser[userID], item)
+	}
+
+	return byUser, rows.Err()
+}
+
+// GetUsersByIDs retrieves the users with the given ids, in no particular order.
+// Unknown ids are skipped.
+func (db *DB) GetUsersByIDs(ctx context.Context, userIDs []uuid.UUID) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ANY($1)`
+
+	return db.queryUsers(ctx, query, pq.Array(userIDs))
+}
+
+// GetArtistsForUsers retrieves the top artists of several users, best ranked first
+func (db *DB) GetArtistsForUsers(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID][]models.Artist, error) {
+	query := `SELECT user_id, id, name, uri, image_url, rank FROM artists WHERE user_id = ANY($1) ORDER BY user_id, rank, name`
+	return queryByUser(ctx, db, query, userIDs, func(rows *sql.Rows, userID *uuid.UUID) (models.Artist, error) {
+		var artist models.Artist
+		err := rows.Scan(userID, &artist.ID, &artist.Name, &artist.Uri, &artist.ImageURL, &artist.Rank)
+		artist.UserID = *userID
+		return artist, err
+	})
+}
+
+// GetSongsForUsers retrieves the top songs of several users, best ranked first
+func (db *DB) GetSongsForUsers(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID][]models.Song, error) {
+	query := `SELECT user_id, id, name, artist, uri, image_url, rank FROM songs WHERE user_id = ANY($1) ORDER BY user_id, rank, name`
+	return queryByUser(ctx, db, query, userIDs, func(rows *sql.Rows, userID *uuid.UUID) (models.Song, error) {
+		var song models.Song
+		err := rows.Scan(userID, &song.ID, &song.Name, &song.Artist, &song.Uri, &song.ImageURL, &song.Rank)
+		song.UserID = *userID
+		return song, err
+	})
+}
+
+// GetPlaylistsForUsers retrieves the saved playlists of several users
+func (db *DB) GetPlaylistsForUsers(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID][]models.Playlist, error) {
+	query := `SELECT user_id, id, name, uri, image_url FROM playlists WHERE user_id = ANY($1) ORDER BY user_id, name`
+	return queryByUser(ctx, db, query, userIDs, func(rows *sql.Rows, userID *uuid.UUID) (models.Playlist, error) {
+		var playlist models.Playlist
+		err := rows.Scan(userID, &playlist.ID, &playlist.Name, &playlist.Uri, &playlist.ImageURL)
+		playlist.UserID = *userID
+		return playlist, err
+	})
+}
+
+// GetGenresForUsers retrieves the genre distributions of several users
+func (db *DB) GetGenresForUsers(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID][]models.GenreShare, error) {
+	query := `SELECT user_id, genre, share FROM user_genres WHERE user_id = ANY($1) ORDER BY user_id, share DESC, genre`
+	return queryByUser(ctx, db, query, userIDs, func(rows *sql.Rows, userID *uuid.UUID) (models.GenreShare, error) {
+		var genre models.GenreShare
+		err := rows.Scan(userID, &genre.Genre, &genre.Share)
+		return genre, err
+	})
+}
+
+// GetVibeVectorsForUsers retrieves the vibe vectors of several users. Users
+// without one are left out.
+func (db *DB) GetVibeVectorsForUsers(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID]*models.VibeVector, error) {
+	query := `SELECT id, vibe_vector FROM users WHERE id = ANY($1) AND vibe_vector IS NOT NULL`
+	byUser, err := queryByUser(ctx, db, query, userIDs, func(rows *sql.Rows, userID *uuid.UUID) (*models.VibeVector, error) {
+		var vectorJSON []byte
+		if err := rows.Scan(userID, &vectorJSON); err != nil {
+			return nil, err
+		}
+		var vector models.VibeVector
+		return &vector, json.Unmarshal(vectorJSON, &vector)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make(map[uuid.UUID]*models.VibeVector, len(byUser))
+	for userID, v := range byUser {
+		vectors[userID] = v[0]
+	}
+	return vectors, nil
+}
+
+// GetInterestRatingsForUsers retrieves the interest ratings of several users
+func (db *DB) GetInterestRatingsForUsers(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID]map[string]int, error) {
+	type rating struct {
+		name  string
+		value int
+	}
+	query := `SELECT user_id, name, rating FROM interest_ratings WHERE user_id = ANY($1)`
+	byUser, err := queryByUser(ctx, db, query, userIDs, func(rows *sql.Rows, userID *uuid.UUID) (rating, error) {
+		var r rating
+		err := rows.Scan(userID, &r.name, &r.value)
+		return r, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ratings := make(map[uuid.UUID]map[string]int, len(byUser))
+	for userID, rs := range byUser {
+		ratings[userID] = make(map[string]int, len(rs))
+		for _, r := range rs {
+			ratings[userID][r.name] = r.value
+		}
+	}
+	return ratings, nil
+}
+
+// GetUserProfiles retrieves the complete profiles of several users with one
+// query per table, in the order of userIDs. Unknown ids are skipped.
+func (db *DB) GetUserProfiles(ctx context.Context, userIDs []uuid.UUID) ([]*models.UserProfile, error) {
+	users, err := db.queryUsers(ctx, `SELECT `+userColumns+` FROM users WHERE id = ANY($1)`, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := queryByUser(ctx, db, `SELECT user_id, data FROM images WHERE user_id = ANY($1)`, userIDs,
+		func(rows *sql.Rows, userID *uuid.UUID) ([]byte, error) {
+			var data []byte
+			err := rows.Scan(userID, &data)
+			return data, err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	interests, err := queryByUser(ctx, db, `SELECT user_id, name FROM interests WHERE user_id = ANY($1)`, userIDs,
+		func(rows *sql.Rows, userID *uuid.UUID) (string, error) {
+			var name string
+			err := rows.Scan(userID, &name)
+			return name, err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	prompts, err := queryByUser(ctx, db, `SELECT user_id, id, question, answer FROM prompts WHERE user_id = ANY($1)`, userIDs,
+		func(rows *sql.Rows, userID *uuid.UUID) (models.Prompt, error) {
+			var prompt models.Prompt
+			err := rows.Scan(userID, &prompt.ID, &prompt.Question, &prompt.Answer)
+			prompt.UserID = *userID
+			return prompt, err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	ratings, err := db.GetInterestRatingsForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	artists, err := db.GetArtistsForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	songs, err := db.GetSongsForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	playlists, err := db.GetPlaylistsForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	genres, err := db.GetGenresForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := db.GetVibeVectorsForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	byID := make(map[uuid.UUID]*models.UserProfile, len(users))
+	for i := range users {
+		id := users[i].ID
+		profile := models.NewUserProfile(&users[i], now)
+		profile.Images = images[id]
+		profile.Interests = interests[id]
+		profile.InterestRating = ratings[id]
+		if profile.InterestRating == nil {
+			profile.InterestRating = map[string]int{}
+		}
+		profile.Prompts = prompts[id]
+		profile.TopArtists = artists[id]
+		profile.TopSongs = songs[id]
+		profile.SavedPlaylists = playlists[id]
+		profile.Genres = genres[id]
+		if profile.Genres == nil {
+			profile.Genres = []models.GenreShare{}
+		}
+		profile.VibeVector = vectors[id]
+		byID[id] = profile
+	}
+
+	profiles := make([]*models.UserProfile, 0, len(byID))
+	for _, id := range userIDs {
+		if profile, ok := byID[id]; ok {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles, nil
+}
@@ -5,17 +5,27 @@ CREATE TABLE IF NOT EXISTS users (
     access_token TEXT NOT NULL,
     refresh_token TEXT NOT NULL,
     token_expiry TIMESTAMP NOT NULL,
+    needs_reauth BOOLEAN NOT NULL DEFAULT FALSE,
+    token_refresh_failures INTEGER NOT NULL DEFAULT 0,
+    token_refresh_retry_at TIMESTAMP,
     name TEXT,
     university_name TEXT,
     work JSONB,
     home_town TEXT,
     height TEXT,
+    height_cm INTEGER,
     age TEXT,
     zodiac TEXT,
     currently_playing TEXT,
     unix_timestamp BIGINT,
     gender TEXT CHECK (gender IN ('Man', 'Woman', 'Non-binary')),
     dating_preference TEXT CHECK (dating_preference IN ('Men', 'Women', 'Everyone')),
+    top_items_synced_at TIMESTAMP,
+    vibe_vector JSONB,
+    latitude DOUBLE PRECISION CHECK (latitude BETWEEN -90 AND 90),
+    longitude DOUBLE PRECISION CHECK (longitude BETWEEN -180 AND 180),
+    geohash TEXT,
+    location_updated_at TIMESTAMP,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
@@ -56,7 +66,8 @@ CREATE TABLE IF NOT EXISTS artists (
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     name TEXT NOT NULL,
     uri TEXT NOT NULL,
-    image_url TEXT
+    image_url TEXT,
+    rank INTEGER NOT NULL DEFAULT 0
 );
 
 -- Create songs table
@@ -66,7 +77,8 @@ CREATE TABLE IF NOT EXISTS songs (
     name TEXT NOT NULL,
     artist TEXT NOT NULL,
     uri TEXT NOT NULL,
-    image_url TEXT
+    image_url TEXT,
+    rank INTEGER NOT NULL DEFAULT 0
 );
 
 -- Create playlists table
@@ -78,11 +90,182 @@ CREATE TABLE IF NOT EXISTS playlists (
     image_url TEXT
 );
 
+-- Create spotify_artists table
+CREATE TABLE IF NOT EXISTS spotify_artists (
+    uri TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    popularity INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Create artist_genres table
+CREATE TABLE IF NOT EXISTS artist_genres (
+    artist_uri TEXT NOT NULL REFERENCES spotify_artists(uri) ON DELETE CASCADE,
+    genre TEXT NOT NULL,
+    PRIMARY KEY (artist_uri, genre)
+);
+
+-- Create user_genres table
+CREATE TABLE IF NOT EXISTS user_genres (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    genre TEXT NOT NULL,
+    share DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (user_id, genre)
+);
+
+-- Create swipes table
+CREATE TABLE IF NOT EXISTS swipes (
+    actor_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    action TEXT NOT NULL CHECK (action IN ('like', 'pass')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (actor_id, target_id),
+    CHECK (actor_id <> target_id)
+);
+
+-- Create matches table
+CREATE TABLE IF NOT EXISTS matches (
+    id UUID PRIMARY KEY,
+    user_a UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_b UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    unmatched_at TIMESTAMP,
+    unmatched_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    last_message_seq BIGINT NOT NULL DEFAULT 0,
+    UNIQUE (user_a, user_b),
+    CHECK (user_a < user_b)
+);
+
+-- Create messages table
+CREATE TABLE IF NOT EXISTS messages (
+    id UUID PRIMARY KEY,
+    match_id UUID NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+    sender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (match_id, seq)
+);
+
+-- Create match_reads table
+CREATE TABLE IF NOT EXISTS match_reads (
+    match_id UUID NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    last_read_seq BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (match_id, user_id)
+);
+
+-- Create vibing_notifications table
+CREATE TABLE IF NOT EXISTS vibing_notifications (
+    match_id UUID PRIMARY KEY REFERENCES matches(id) ON DELETE CASCADE,
+    level TEXT NOT NULL,
+    uri TEXT NOT NULL,
+    notified_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Create match_blends table
+CREATE TABLE IF NOT EXISTS match_blends (
+    match_id UUID NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    playlist_id TEXT NOT NULL,
+    playlist_uri TEXT NOT NULL,
+    track_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (match_id, user_id)
+);
+
+-- Create blocks table
+CREATE TABLE IF NOT EXISTS blocks (
+    blocker_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+-- Create reports table
+CREATE TABLE IF NOT EXISTS reports (
+    id UUID PRIMARY KEY,
+    reporter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reported_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL CHECK (reason IN ('spam', 'harassment', 'inappropriate_content', 'fake_profile', 'underage', 'other')),
+    details TEXT,
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'actioned', 'dismissed')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP
+);
+
+-- Create listening_history table
+CREATE TABLE IF NOT EXISTS listening_history (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    track_uri TEXT NOT NULL,
+    track_name TEXT NOT NULL,
+    artist TEXT NOT NULL,
+    artist_uris TEXT[] NOT NULL DEFAULT '{}',
+    album TEXT NOT NULL,
+    album_uri TEXT NOT NULL,
+    image_url TEXT,
+    duration_ms INTEGER NOT NULL,
+    context_uri TEXT,
+    context_type TEXT,
+    played_at TIMESTAMP NOT NULL,
+    UNIQUE (user_id, played_at, track_uri)
+);
+
+-- Create listening_history_cursors table
+CREATE TABLE IF NOT EXISTS listening_history_cursors (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    after_ms BIGINT NOT NULL DEFAULT 0,
+    synced_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    failures INTEGER NOT NULL DEFAULT 0,
+    retry_at TIMESTAMP
+);
+
+-- Create discovery_sessions table
+CREATE TABLE IF NOT EXISTS discovery_sessions (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    candidate_ids UUID[] NOT NULL,
+    ranked_ids UUID[] NOT NULL DEFAULT '{}',
+    ranked_through INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Create discovery_preferences table
+CREATE TABLE IF NOT EXISTS discovery_preferences (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    min_age INTEGER CHECK (min_age BETWEEN 18 AND 100),
+    max_age INTEGER CHECK (max_age BETWEEN 18 AND 100),
+    max_distance_km INTEGER CHECK (max_distance_km BETWEEN 1 AND 500),
+    min_height_cm INTEGER CHECK (min_height_cm BETWEEN 100 AND 250),
+    max_height_cm INTEGER CHECK (max_height_cm BETWEEN 100 AND 250),
+    min_shared_genres INTEGER NOT NULL DEFAULT 0 CHECK (min_shared_genres BETWEEN 0 AND 25),
+    min_shared_artists INTEGER NOT NULL DEFAULT 0 CHECK (min_shared_artists BETWEEN 0 AND 50),
+    dealbreakers TEXT[] NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
 -- Create indexes for faster queries
+CREATE INDEX IF NOT EXISTS idx_users_token_expiry ON users(token_expiry) WHERE NOT needs_reauth;
 CREATE INDEX IF NOT EXISTS idx_images_user_id ON images(user_id);
 CREATE INDEX IF NOT EXISTS idx_interests_user_id ON interests(user_id);
 CREATE INDEX IF NOT EXISTS idx_interest_ratings_user_id ON interest_ratings(user_id);
 CREATE INDEX IF NOT EXISTS idx_prompts_user_id ON prompts(user_id);
 CREATE INDEX IF NOT EXISTS idx_artists_user_id ON artists(user_id);
 CREATE INDEX IF NOT EXISTS idx_songs_user_id ON songs(user_id);
-CREATE INDEX IF NOT EXISTS idx_playlists_user_id ON playlists(user_id); 
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_playlists_user_id ON playlists(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_playlists_user_id_uri ON playlists(user_id, uri); 
+CREATE INDEX IF NOT EXISTS idx_listening_history_user_id_played_at ON listening_history(user_id, played_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_artist_genres_genre ON artist_genres(genre);
+CREATE INDEX IF NOT EXISTS idx_discovery_sessions_user_id_created_at ON discovery_sessions(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_swipes_target_id ON swipes(target_id);
+CREATE INDEX IF NOT EXISTS idx_matches_user_b ON matches(user_b);
+CREATE INDEX IF NOT EXISTS idx_blocks_blocked_id ON blocks(blocked_id);
+CREATE INDEX IF NOT EXISTS idx_reports_status_created_at ON reports(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_reports_reported_id ON reports(reported_id);
+CREATE INDEX IF NOT EXISTS idx_users_height_cm ON users(height_cm);
+CREATE INDEX IF NOT EXISTS idx_user_genres_genre ON user_genres(genre);
+CREATE INDEX IF NOT EXISTS idx_users_latitude_longitude ON users(latitude, longitude);
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// orderPair returns two user ids smallest first, the order matches are stored in
+func orderPair(a, b uuid.UUID) (uuid.UUID, uuid.UUID) {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		return b, a
+	}
+	return a, b
+}
+
+// lockPair takes a transaction scoped advisory lock on a pair of users so
+// concurrent swipes between them are applied one at a time
+func lockPair(ctx context.Context, tx *sql.Tx, a, b uuid.UUID) error {
+	first, second := orderPair(a, b)
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, first.String()+":"+second.String())
+	return err
+}
+
+// Swipe records that actorID liked or passed on targetID. A like that is
+// reciprocated creates a match, which is returned. Otherwise it returns nil,
+// also when the pair was matched before. Swiping again replaces the earlier
+// action but never removes a match.
+func (db *DB) Swipe(ctx context.Context, actorID, targetID uuid.UUID, action string) (*models.Match, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Both users liking each other at once must still create exactly one match
+	if err := lockPair(ctx, tx, actorID, targetID); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO swipes (actor_id, target_id, action, created_at) VALUES ($1, $2, $3, NOW())
+			 ON CONFLICT (actor_id, target_id) DO UPDATE SET action = $3, created_at = NOW()`
+	if _, err := tx.ExecContext(ctx, query, actorID, targetID, action); err != nil {
+		return nil, err
+	}
+
+	if action != models.SwipeLike {
+		return nil, tx.Commit()
+	}
+
+	// Blocked pairs never match, even if both liked each other before the block
+	var reciprocated bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM swipes WHERE actor_id = $1 AND target_id = $2 AND action = $3)
+		 AND NOT EXISTS (SELECT 1 FROM blocks WHERE (blocker_id = $1 AND blocked_id = $4) OR (blocker_id = $4 AND blocked_id = $1))`,
+		targetID, actorID, models.SwipeLike, actorID,
+	).Scan(&reciprocated)
+	if err != nil {
+		return nil, err
+	}
+	if !reciprocated {
+		return nil, tx.Commit()
+	}
+
+	userA, userB := orderPair(actorID, targetID)
+	match := models.Match{ID: uuid.New(), UserA: userA, UserB: userB}
+	query = `INSERT INTO matches (id, user_a, user_b, created_at) VALUES ($1, $2, $3, NOW())
+			 ON CONFLICT (user_a, user_b) DO NOTHING
+			 RETURNING created_at`
+	err = tx.QueryRowContext(ctx, query, match.ID, userA, userB).Scan(&match.CreatedAt)
+	// The pair was already matched. Liking again doesn't match them twice and
+	// never brings back a match they unmatched.
+	if err == sql.ErrNoRows {
+		return nil, tx.Commit()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &match, nil
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/secrets"
+)
+
+// tokenAAD binds an encrypted token to its user and column so it can't be
+// copied into another row
+func tokenAAD(userID uuid.UUID, column string) string {
+	return column + ":" + userID.String()
+}
+
+// sealTokens encrypts a user's access and refresh token for storage
+func (db *DB) sealTokens(userID uuid.UUID, accessToken, refreshToken string) (string, string, error) {
+	if db.Keyring == nil {
+		return accessToken, refreshToken, nil
+	}
+
+	sealedAccess, err := db.Keyring.Encrypt(accessToken, tokenAAD(userID, "access_token"))
+	if err != nil {
+		return "", "", err
+	}
+	sealedRefresh, err := db.Keyring.Encrypt(refreshToken, tokenAAD(userID, "refresh_token"))
+	if err != nil {
+		return "", "", err
+	}
+	return sealedAccess, sealedRefresh, nil
+}
+
+// openToken decrypts a stored token. Legacy plaintext tokens are returned as is.
+func (db *DB) openToken(userID uuid.UUID, column, value string) (string, error) {
+	if db.Keyring == nil {
+		if secrets.IsEncrypted(value) {
+			return "", errors.New("token is encrypted but no encryption keys are configured")
+		}
+		return value, nil
+	}
+	return db.Keyring.Decrypt(value, tokenAAD(userID, column))
+}
+
+// unrotatedTokens matches users with a token that is not encrypted under the
+// key whose prefix is $1
+const unrotatedTokens = `left(access_token, length($1)) <> $1 OR left(refresh_token, length($1)) <> $1`
+
+// RotateTokenEncryption re-encrypts up to batchSize users' tokens that are in
+// plaintext or encrypted under an old key with the keyring's current key. Rows
+// are locked while they are rotated, so concurrent token refreshes wait rather
+// than being overwritten. Rows a refresh has locked are skipped instead of
+// waited for, so a result of zero only means no unlocked row is left; use
+// CountUnrotatedTokens to check that every row was rotated.
+func (db *DB) RotateTokenEncryption(ctx context.Context, batchSize int) (int, error) {
+	if db.Keyring == nil {
+		return 0, errors.New("no encryption keys are configured")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	prefix := db.Keyring.CurrentPrefix()
+	query := `SELECT id, access_token, refresh_token FROM users
+			 WHERE ` + unrotatedTokens + `
+			 ORDER BY id LIMIT $2
+			 FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, prefix, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	type storedTokens struct {
+		userID       uuid.UUID
+		accessToken  string
+		refreshToken string
+	}
+	var batch []storedTokens
+	for rows.Next() {
+		var t storedTokens
+		if err := rows.Scan(&t.userID, &t.accessToken, &t.refreshToken); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, t := range batch {
+		accessToken, err := db.openToken(t.userID, "access_token", t.accessToken)
+		if err != nil {
+			return 0, fmt.Errorf("user %s: %w", t.userID, err)
+		}
+		refreshToken, err := db.openToken(t.userID, "refresh_token", t.refreshToken)
+		if err != nil {
+			return 0, fmt.Errorf("user %s: %w", t.userID, err)
+		}
+
+		sealedAccess, sealedRefresh, err := db.sealTokens(t.userID, accessToken, refreshToken)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET access_token = $1, refresh_token = $2 WHERE id = $3`, sealedAccess, sealedRefresh, t.userID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+// CountUnrotatedTokens counts the users whose tokens are in plaintext or
+// encrypted under an old key
+func (db *DB) CountUnrotatedTokens(ctx context.Context) (int, error) {
+	if db.Keyring == nil {
+		return 0, errors.New("no encryption keys are configured")
+	}
+
+	var count int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE `+unrotatedTokens, db.Keyring.CurrentPrefix()).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// saveVibeVector stores a user's vibe vector. A nil vector is stored as SQL
+// NULL rather than a JSON null.
+func saveVibeVector(ctx context.Context, ex execer, userID uuid.UUID, vector *models.VibeVector) error {
+	if vector == nil {
+		_, err := ex.ExecContext(ctx, `UPDATE users SET vibe_vector = NULL WHERE id = $1`, userID)
+		return err
+	}
+
+	vectorJSON, err := json.Marshal(vector)
+	if err != nil {
+		return err
+	}
+	_, err = ex.ExecContext(ctx, `UPDATE users SET vibe_vector = $1 WHERE id = $2`, vectorJSON, userID)
+	return err
+}
+
+// GetUserVibeVector retrieves a user's vibe vector, or nil if it was never computed
+func (db *DB) GetUserVibeVector(ctx context.Context, userID uuid.UUID) (*models.VibeVector, error) {
+	var vectorJSON []byte
+	err := db.QueryRowContext(ctx, `SELECT vibe_vector FROM users WHERE id = $1`, userID).Scan(&vectorJSON)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if vectorJSON == nil {
+		return nil, nil
+	}
+
+	var vector models.VibeVector
+	if err := json.Unmarshal(vectorJSON, &vector); err != nil {
+		return nil, err
+	}
+	return &vector, nil
+}
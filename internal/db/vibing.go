@@ -0,0 +1,32 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ClaimVibingNotification records that the users of a match are being told
+// they are listening to the same thing. It reports false, and records nothing,
+// when the match was already told within cooldown, so concurrent updates from
+// both users notify at most once.
+func (db *DB) ClaimVibingNotification(ctx context.Context, matchID uuid.UUID, level, uri string, cooldown time.Duration) (bool, error) {
+	query := `INSERT INTO vibing_notifications (match_id, level, uri, notified_at)
+			 VALUES ($1, $2, $3, NOW())
+			 ON CONFLICT (match_id) DO UPDATE
+			 SET level = EXCLUDED.level, uri = EXCLUDED.uri, notified_at = EXCLUDED.notified_at
+			 WHERE vibing_notifications.notified_at <= NOW() - $4 * INTERVAL '1 millisecond'
+			 RETURNING match_id`
+
+	var claimed uuid.UUID
+	err := db.QueryRowContext(ctx, query, matchID, level, uri, cooldown.Milliseconds()).Scan(&claimed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,124 @@
+// Package geo computes distances and geohashes on plain coordinates, so
+// locations work without PostGIS
+package geo
+
+import (
+	"math"
+	"strings"
+)
+
+// earthRadiusKM is the mean radius of the Earth
+const earthRadiusKM = 6371.0
+
+// Precision locations are stored with. One decimal is about 11 km of latitude,
+// so stored coordinates never narrow someone down to a neighborhood, and a
+// geohash of five characters is a cell of about 5 km.
+const (
+	CoordinateDecimals = 1
+	GeohashPrecision   = 5
+)
+
+// geohashAlphabet is the base32 alphabet of geohashes
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Valid reports whether the coordinates are a point on Earth
+func Valid(lat, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// Round rounds a coordinate to CoordinateDecimals decimals
+func Round(coordinate float64) float64 {
+	scale := math.Pow(10, CoordinateDecimals)
+	return math.Round(coordinate*scale) / scale
+}
+
+// DistanceKM returns the great circle distance between two points with the
+// haversine formula
+func DistanceKM(lat1, lng1, lat2, lng2 float64) float64 {
+	dLat := radians(lat2 - lat1)
+	dLng := radians(lng2 - lng1)
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(radians(lat1))*math.Cos(radians(lat2))*math.Pow(math.Sin(dLng/2), 2)
+	return 2 * earthRadiusKM * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// MinCoarseKM is the smallest distance CoarseKM reports, anything closer is
+// "within 5 km"
+const MinCoarseKM = 5
+
+// CoarseKM rounds a distance up so that it is useful without revealing where
+// someone is: steps of 5 kilometers up to 100 and of 10 beyond. It is never
+// less than MinCoarseKM.
+func CoarseKM(km float64) int {
+	step := 5.0
+	if km > 100 {
+		step = 10
+	}
+	coarse := int(math.Ceil(km/step) * step)
+	if coarse < MinCoarseKM {
+		return MinCoarseKM
+	}
+	return coarse
+}
+
+// Box is a latitude and longitude range
+type Box struct {
+	MinLat, MaxLat float64
+	MinLng, MaxLng float64
+}
+
+// BoundingBox returns a box containing every point within km of the point.
+// Near the poles and across the antimeridian it spans every longitude.
+func BoundingBox(lat, lng, km float64) Box {
+	dLat := km / earthRadiusKM * 180 / math.Pi
+	box := Box{
+		MinLat: math.Max(lat-dLat, -90),
+		MaxLat: math.Min(lat+dLat, 90),
+		MinLng: -180,
+		MaxLng: 180,
+	}
+
+	if box.MinLat > -90 && box.MaxLat < 90 {
+		dLng := dLat / math.Cos(radians(lat))
+		if lng-dLng >= -180 && lng+dLng <= 180 {
+			box.MinLng, box.MaxLng = lng-dLng, lng+dLng
+		}
+	}
+	return box
+}
+
+// Geohash encodes a point as a geohash of the given number of characters
+func Geohash(lat, lng float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lngRange := [2]float64{-180, 180}
+
+	var hash strings.Builder
+	bit, ch := 0, 0
+	evenBit := true
+	for hash.Len() < precision {
+		// Bits alternate between longitude and latitude, starting with longitude
+		value, bounds := lat, &latRange
+		if evenBit {
+			value, bounds = lng, &lngRange
+		}
+
+		mid := (bounds[0] + bounds[1]) / 2
+		ch <<= 1
+		if value >= mid {
+			ch |= 1
+			bounds[0] = mid
+		} else {
+			bounds[1] = mid
+		}
+		evenBit = !evenBit
+
+		if bit++; bit == 5 {
+			hash.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return hash.String()
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -8,22 +11,46 @@ import (
 	"github.com/matchmyvibe/backend/internal/auth"
 	"github.com/matchmyvibe/backend/internal/db"
 	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/musicsync"
 	"github.com/matchmyvibe/backend/internal/spotify"
 )
 
+// loginSyncTimeout bounds the background Spotify sync started on login
+const loginSyncTimeout = 2 * time.Minute
+
 // AuthHandler handles authentication-related requests
 type AuthHandler struct {
 	DB            *db.DB
 	JWTService    *auth.JWTService
 	SpotifyClient *spotify.Client
+	MusicSync     *musicsync.Service
+
+	// AllowLegacyTokenAuth accepts the old request shape where the client
+	// supplies the Spotify URI and tokens directly. The tokens are not verified
+	// against Spotify, so this must stay disabled in production.
+	AllowLegacyTokenAuth bool
 }
 
-// SpotifyAuthRequest represents a request for authenticating with Spotify
+// SpotifyAuthRequest represents a request for authenticating with Spotify.
+// Clients send the authorization code and PKCE verifier from the Spotify
+// authorize redirect; the server exchanges them and looks up the Spotify user.
 type SpotifyAuthRequest struct {
-	SpotifyURI   string    `json:"spotify_uri" binding:"required"`
-	AccessToken  string    `json:"access_token" binding:"required"`
-	RefreshToken string    `json:"refresh_token" binding:"required"`
-	ExpiryDate   time.Time `json:"expiry_date" binding:"required"`
+	Code         string `json:"code"`
+	CodeVerifier string `json:"code_verifier"`
+
+	// Legacy token passthrough fields, only honoured when AllowLegacyTokenAuth is set
+	SpotifyURI   string    `json:"spotify_uri"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiryDate   time.Time `json:"expiry_date"`
+}
+
+// spotifyCredentials holds the verified Spotify identity and tokens for a login
+type spotifyCredentials struct {
+	SpotifyURI   string
+	AccessToken  string
+	RefreshToken string
+	TokenExpiry  time.Time
 }
 
 // AuthResponse represents the response from an authentication request
@@ -35,14 +62,56 @@ type AuthResponse struct {
 
 // SpotifyAuth handles authentication with Spotify
 func (h *AuthHandler) SpotifyAuth(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	var req SpotifyAuthRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	var creds *spotifyCredentials
+	switch {
+	case req.Code != "":
+		if req.CodeVerifier == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code_verifier is required"})
+			return
+		}
+
+		var err error
+		creds, err = h.exchangeSpotifyCode(ctx, req.Code, req.CodeVerifier)
+		if err != nil {
+			fmt.Printf("[ERROR] SpotifyAuth - Error exchanging authorization code: %v\n", err)
+			if errors.Is(err, spotify.ErrRateLimited) || errors.Is(err, spotify.ErrUpstream) {
+				respondSpotifyError(c, err, "spotify authorization failed")
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "spotify authorization failed"})
+			return
+		}
+	case req.SpotifyURI != "" || req.AccessToken != "":
+		if !h.AllowLegacyTokenAuth {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token passthrough is no longer supported, send code and code_verifier"})
+			return
+		}
+		if req.SpotifyURI == "" || req.AccessToken == "" || req.RefreshToken == "" || req.ExpiryDate.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "spotify_uri, access_token, refresh_token and expiry_date are required"})
+			return
+		}
+
+		creds = &spotifyCredentials{
+			SpotifyURI:   req.SpotifyURI,
+			AccessToken:  req.AccessToken,
+			RefreshToken: req.RefreshToken,
+			TokenExpiry:  req.ExpiryDate,
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code and code_verifier are required"})
+		return
+	}
+
 	// Check if the user exists
-	user, err := h.DB.GetUserBySpotifyURI(req.SpotifyURI)
+	user, err := h.DB.GetUserBySpotifyURI(ctx, creds.SpotifyURI)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error checking for existing user"})
 		return
@@ -52,18 +121,34 @@ func (h *AuthHandler) SpotifyAuth(c *gin.Context) {
 	// If user doesn't exist, create a new one
 	if user == nil {
 		isNewUser = true
-		user, err = h.DB.CreateUser(req.SpotifyURI, req.AccessToken, req.RefreshToken, req.ExpiryDate)
+		user, err = h.DB.CreateUser(ctx, creds.SpotifyURI, creds.AccessToken, creds.RefreshToken, creds.TokenExpiry)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating new user"})
 			return
 		}
 	} else {
 		// Update the user's Spotify tokens
-		err = h.DB.UpdateSpotifyTokens(user.ID, req.AccessToken, req.RefreshToken, req.ExpiryDate)
+		err = h.DB.UpdateSpotifyTokens(ctx, user.ID, creds.AccessToken, creds.RefreshToken, creds.TokenExpiry)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error updating user tokens"})
 			return
 		}
+		user.AccessToken = creds.AccessToken
+		user.RefreshToken = creds.RefreshToken
+		user.TokenExpiry = creds.TokenExpiry
+	}
+
+	// Refresh the user's top items in the background so login stays fast
+	if h.MusicSync != nil {
+		go func(user *models.User) {
+			// The request context ends with the response, so the sync gets its own deadline
+			syncCtx, cancel := context.WithTimeout(context.Background(), loginSyncTimeout)
+			defer cancel()
+
+			if _, _, err := h.MusicSync.SyncIfStale(syncCtx, user); err != nil {
+				fmt.Printf("[ERROR] SpotifyAuth - Error syncing Spotify data for user %s: %v\n", user.ID, err)
+			}
+		}(user)
 	}
 
 	// Generate a JWT token
@@ -76,7 +161,7 @@ func (h *AuthHandler) SpotifyAuth(c *gin.Context) {
 	// Get user profile if not a new user
 	var userProfile *models.UserProfile
 	if !isNewUser {
-		userProfile, err = h.DB.GetFullUserProfile(user.ID)
+		userProfile, err = h.DB.GetFullUserProfile(ctx, user.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user profile"})
 			return
@@ -90,6 +175,27 @@ func (h *AuthHandler) SpotifyAuth(c *gin.Context) {
 	})
 }
 
+// exchangeSpotifyCode trades an authorization code for tokens and resolves the
+// Spotify user that owns them, so the identity never comes from the client
+func (h *AuthHandler) exchangeSpotifyCode(ctx context.Context, code, codeVerifier string) (*spotifyCredentials, error) {
+	tokenResponse, err := h.SpotifyClient.ExchangeCode(ctx, code, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	spotifyUser, err := h.SpotifyClient.GetCurrentUser(ctx, tokenResponse.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching spotify profile: %w", err)
+	}
+
+	return &spotifyCredentials{
+		SpotifyURI:   spotifyUser.URI,
+		AccessToken:  tokenResponse.AccessToken,
+		RefreshToken: tokenResponse.RefreshToken,
+		TokenExpiry:  time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second),
+	}, nil
+}
+
 // RefreshToken handles token refresh requests
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	tokenString := c.GetHeader("Authorization")
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/matchmyvibe/backend/internal/spotify/spotifytest"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// postJSON sends body as JSON to handler and returns the recorded response
+func postJSON(t *testing.T, handler gin.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	router := gin.New()
+	router.POST("/", handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(payload)))
+	return w
+}
+
+// newAuthHandler returns an AuthHandler talking to a fake Spotify server.
+// It has no database, so only paths that fail before storing the user work.
+func newAuthHandler(t *testing.T) (*AuthHandler, *spotifytest.Server) {
+	t.Helper()
+
+	server := spotifytest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddUser(spotifytest.User{ID: "alice", DisplayName: "Alice"})
+
+	return &AuthHandler{SpotifyClient: server.Client()}, server
+}
+
+func TestSpotifyAuthRejectsInvalidCode(t *testing.T) {
+	h, server := newAuthHandler(t)
+	server.AddAuthCode("code-1", "verifier-1", "alice")
+
+	w := postJSON(t, h.SpotifyAuth, SpotifyAuthRequest{Code: "code-1", CodeVerifier: "someone-elses-verifier"})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+
+	// The profile is never fetched for a failed exchange
+	for _, req := range server.Requests() {
+		if req.Path != "/api/token" {
+			t.Errorf("unexpected request to %s", req.Path)
+		}
+	}
+}
+
+func TestSpotifyAuthSpotifyUnavailable(t *testing.T) {
+	h, server := newAuthHandler(t)
+	server.AddAuthCode("code-1", "verifier-1", "alice")
+	server.Fail("/api/token", spotifytest.Failure{Status: http.StatusTooManyRequests, RetryAfter: 120})
+
+	// A busy Spotify is not a failed login, the client should retry later
+	w := postJSON(t, h.SpotifyAuth, SpotifyAuthRequest{Code: "code-1", CodeVerifier: "verifier-1"})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusServiceUnavailable, w.Body)
+	}
+	if got := w.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("Retry-After = %q, want 120", got)
+	}
+}
+
+func TestSpotifyAuthRequiresCodeVerifier(t *testing.T) {
+	h, server := newAuthHandler(t)
+
+	w := postJSON(t, h.SpotifyAuth, SpotifyAuthRequest{Code: "code-1"})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	if n := len(server.Requests()); n != 0 {
+		t.Errorf("spotify requests = %d, want 0", n)
+	}
+}
+
+func TestSpotifyAuthRejectsTokenPassthrough(t *testing.T) {
+	h, server := newAuthHandler(t)
+	user := server.AddUser(spotifytest.User{ID: "mallory"})
+
+	w := postJSON(t, h.SpotifyAuth, SpotifyAuthRequest{
+		SpotifyURI:   "spotify:user:alice",
+		AccessToken:  user.AccessToken,
+		RefreshToken: user.RefreshToken,
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+}
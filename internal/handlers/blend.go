@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/matching"
+	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/spotify"
+)
+
+// blendDescription is the description of blended playlists on Spotify
+const blendDescription = "Your top songs and your match's, blended by MatchMyVibe."
+
+// Blend creates a private Spotify playlist in the user's account that mixes
+// their top songs with the other user's. Blending again refreshes the songs
+// of the playlist created before instead of creating another one.
+func (h *MatchHandler) Blend(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	match := h.activeMatch(c, userID)
+	if match == nil {
+		return
+	}
+	otherID := match.OtherUser(userID)
+
+	user, err := h.DB.GetUserByID(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
+		return
+	}
+	other, err := h.DB.GetUserByID(ctx, otherID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
+		return
+	}
+	if user == nil || other == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	songs, err := h.DB.GetSongsForUsers(ctx, []uuid.UUID{userID, otherID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving top songs"})
+		return
+	}
+
+	blended := matching.Blend(songs[userID], songs[otherID], models.MaxBlendSongs)
+	if len(blended) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "there are no top songs to blend yet"})
+		return
+	}
+	uris := make([]string, len(blended))
+	for i, song := range blended {
+		uris[i] = song.Uri
+	}
+
+	accessToken, err := h.Tokens.AccessToken(ctx, user)
+	if err != nil {
+		respondSpotifyError(c, err, "error refreshing Spotify token")
+		return
+	}
+
+	// Blends of the same match by the same user run one at a time, so the
+	// second one refreshes the playlist the first one created
+	lock, err := h.DB.LockMatchBlend(ctx, match.ID, userID)
+	if err != nil {
+		fmt.Printf("[ERROR] Blend - Error locking blend of match %s for user %s: %v\n", match.ID, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving blend"})
+		return
+	}
+	defer lock.Release()
+
+	blend, err := h.DB.GetMatchBlend(ctx, match.ID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving blend"})
+		return
+	}
+
+	if blend != nil {
+		err := h.SpotifyClient.ReplacePlaylistItems(ctx, accessToken, blend.PlaylistID, uris)
+		if isSpotifyNotFound(err) {
+			// The playlist is gone on Spotify, make a new one
+			fmt.Printf("[DEBUG] Blend - Playlist %s of match %s no longer exists\n", blend.PlaylistID, match.ID)
+			blend = nil
+		} else if err != nil {
+			respondSpotifyError(c, err, "error updating blend playlist")
+			return
+		}
+	}
+
+	created := blend == nil
+	if created {
+		playlist, err := h.SpotifyClient.CreatePlaylist(ctx, accessToken, spotifyUserID(user.SpotifyURI), blendName(user, other), blendDescription, false)
+		if err != nil {
+			respondSpotifyError(c, err, "error creating blend playlist")
+			return
+		}
+
+		// Save the playlist before filling it, so a failure below leaves a
+		// playlist that the next blend refreshes instead of an orphan
+		blend = &models.MatchBlend{
+			MatchID:     match.ID,
+			UserID:      userID,
+			PlaylistID:  playlist.ID,
+			PlaylistURI: playlist.URI,
+		}
+		if err := h.DB.SaveMatchBlend(ctx, blend); err != nil {
+			fmt.Printf("[ERROR] Blend - Error saving blend of match %s for user %s: %v\n", match.ID, userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error saving blend"})
+			return
+		}
+
+		if err := h.SpotifyClient.AddPlaylistItems(ctx, accessToken, playlist.ID, uris); err != nil {
+			respondSpotifyError(c, err, "error adding songs to blend playlist")
+			return
+		}
+	}
+
+	blend.TrackCount = len(uris)
+	if err := h.DB.SaveMatchBlend(ctx, blend); err != nil {
+		fmt.Printf("[ERROR] Blend - Error saving blend of match %s for user %s: %v\n", match.ID, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error saving blend"})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, blend)
+}
+
+// blendName names a blended playlist after both users
+func blendName(user, other *models.User) string {
+	name, otherName := "You", "your match"
+	if user.Name != nil && *user.Name != "" {
+		name = *user.Name
+	}
+	if other.Name != nil && *other.Name != "" {
+		otherName = *other.Name
+	}
+	return fmt.Sprintf("%s + %s", name, otherName)
+}
+
+// spotifyUserID returns the Spotify user id of a spotify:user: URI
+func spotifyUserID(spotifyURI string) string {
+	return strings.TrimPrefix(spotifyURI, "spotify:user:")
+}
+
+// isSpotifyNotFound reports whether Spotify answered 404 Not Found
+func isSpotifyNotFound(err error) bool {
+	var spotifyErr *spotify.Error
+	return errors.As(err, &spotifyErr) && spotifyErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// encodeCursor turns a pagination position into an opaque string clients
+// send back to fetch the next page
+func encodeCursor(position interface{}) string {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor reads a cursor produced by encodeCursor into position
+func decodeCursor(cursor string, position interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, position)
+}
@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/matching"
+	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+const (
+	defaultDiscoverLimit = 20
+	maxDiscoverLimit     = 50
+	// discoverPoolSize is how many candidates are ranked at a time. The feed
+	// goes through the session's candidates one pool after the other, most
+	// recently active first, and is ranked within each pool.
+	discoverPoolSize = 500
+	// maxDiscoverPools caps how many pools one request ranks to fill a page,
+	// the next cursor continues with the following pool
+	maxDiscoverPools = 4
+	// discoverSessionTTL is how long a discovery cursor can be used before the
+	// feed has to be started over
+	discoverSessionTTL = 24 * time.Hour
+	// defaultAgeSpread is how many years around the user's own age are shown
+	// unless min_age and max_age are given
+	defaultAgeSpread = 5
+	minAge           = models.MinAge
+	maxAge           = models.MaxAge
+	// unmetPreferencePenalty is how many points each soft preference a
+	// candidate misses takes off their rank
+	unmetPreferencePenalty = 10
+)
+
+// DiscoverHandler handles the discovery feed
+type DiscoverHandler struct {
+	DB *db.DB
+}
+
+// discoverCursor is a position in a discovery session's ranked candidates
+type discoverCursor struct {
+	Session uuid.UUID `json:"s"`
+	Offset  int       `json:"o"`
+}
+
+// scoredCandidate is a candidate with their compatibility with the user and
+// the soft preferences of the user they miss
+type scoredCandidate struct {
+	id    uuid.UUID
+	score int
+	unmet []string
+}
+
+// rank orders candidates, compatibility minus a penalty per unmet preference
+func (c scoredCandidate) rank() int {
+	return c.score - unmetPreferencePenalty*len(c.unmet)
+}
+
+// Discover returns users the caller could match with, most compatible first.
+// The user's dealbreakers filter candidates and every soft preference a
+// candidate misses ranks them lower. The first page snapshots every candidate
+// into a discovery session that the cursor pages through, so nobody is
+// repeated or skipped while last active times and scores change.
+func (h *DiscoverHandler) Discover(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	user, err := h.DB.GetUserByID(ctx, userID)
+	if err != nil || user == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
+		return
+	}
+	if user.Gender == nil || user.DatingPreference == nil || user.BirthdayInUnix == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "set your gender, dating preference and birthday to use discovery"})
+		return
+	}
+
+	prefs, err := h.DB.GetDiscoveryPreferences(ctx, userID)
+	if err != nil {
+		fmt.Printf("[ERROR] Discover - Error loading preferences for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error loading discovery feed"})
+		return
+	}
+	if prefs == nil {
+		prefs = &models.DiscoveryPreferences{}
+	}
+
+	// The age range is the stored preference or a few years around the
+	// user's own age, min_age and max_age override it for this request
+	now := time.Now()
+	age := models.AgeAt(*user.BirthdayInUnix, now)
+	lowAge, highAge := clampAge(age-defaultAgeSpread), clampAge(age+defaultAgeSpread)
+	if prefs.IsSet(models.PreferenceAge) {
+		lowAge, highAge = minAge, maxAge
+		if prefs.MinAge != nil {
+			lowAge = *prefs.MinAge
+		}
+		if prefs.MaxAge != nil {
+			highAge = *prefs.MaxAge
+		}
+	}
+	hardAge := !prefs.IsSet(models.PreferenceAge) || prefs.IsDealbreaker(models.PreferenceAge) ||
+		c.Query("min_age") != "" || c.Query("max_age") != ""
+	if lowAge, err = intQuery(c, "min_age", lowAge, minAge, maxAge); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if highAge, err = intQuery(c, "max_age", highAge, minAge, maxAge); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if lowAge > highAge {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_age must not be greater than max_age"})
+		return
+	}
+
+	limit, err := intQuery(c, "limit", defaultDiscoverLimit, 1, maxDiscoverLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var after discoverCursor
+	if cursor := c.Query("cursor"); cursor != "" {
+		if err := decodeCursor(cursor, &after); err != nil || after.Session == uuid.Nil || after.Offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+	}
+
+	// Dealbreakers filter candidates out, soft preferences are checked when
+	// candidates are scored
+	filter := db.DiscoveryFilter{
+		UserID:            userID,
+		Genders:           models.GendersFor(*user.DatingPreference),
+		DatingPreferences: models.PreferencesAccepting(*user.Gender),
+	}
+	filterLow, filterHigh := minAge, maxAge
+	if hardAge {
+		filterLow, filterHigh = lowAge, highAge
+	}
+	// Someone is filterLow once their filterLow birthday has passed and stays
+	// filterHigh until the day before their filterHigh+1 birthday
+	filter.BornAfter = now.AddDate(-(filterHigh+1), 0, 0).Unix() + 1
+	filter.BornBefore = now.AddDate(-filterLow, 0, 0).Unix()
+	if prefs.IsDealbreaker(models.PreferenceHeight) {
+		filter.MinHeightCM, filter.MaxHeightCM = prefs.MinHeightCM, prefs.MaxHeightCM
+	}
+	if prefs.IsDealbreaker(models.PreferenceSharedArtists) {
+		filter.MinSharedArtists = prefs.MinSharedArtists
+	}
+	if prefs.IsDealbreaker(models.PreferenceSharedGenres) {
+		filter.MinSharedGenres = prefs.MinSharedGenres
+	}
+	// Distances need the user's location, without one the distance
+	// preference can't be applied
+	filter.Origin = user.Location
+	if prefs.IsDealbreaker(models.PreferenceDistance) {
+		filter.MaxDistanceKM = prefs.MaxDistanceKM
+	}
+
+	var session *db.DiscoverySession
+	if after.Session == uuid.Nil {
+		candidates, err := h.DB.GetDiscoveryCandidates(ctx, filter)
+		if err != nil {
+			fmt.Printf("[ERROR] Discover - Error loading candidates for user %s: %v\n", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error loading discovery feed"})
+			return
+		}
+		candidateIDs := make([]uuid.UUID, len(candidates))
+		for i, candidate := range candidates {
+			candidateIDs[i] = candidate.ID
+		}
+		session, err = h.DB.CreateDiscoverySession(ctx, userID, candidateIDs, now.Add(-discoverSessionTTL))
+		if err != nil {
+			fmt.Printf("[ERROR] Discover - Error creating session for user %s: %v\n", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error loading discovery feed"})
+			return
+		}
+	} else {
+		session, err = h.DB.GetDiscoverySession(ctx, after.Session, userID)
+		if err != nil {
+			fmt.Printf("[ERROR] Discover - Error loading session for user %s: %v\n", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error loading discovery feed"})
+			return
+		}
+		if session == nil || session.CreatedAt.Before(now.Add(-discoverSessionTTL)) || after.Offset > len(session.RankedIDs) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+	}
+
+	tastes, err := matching.LoadTastes(ctx, h.DB, []uuid.UUID{userID})
+	if err != nil {
+		fmt.Printf("[ERROR] Discover - Error loading taste for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error loading discovery feed"})
+		return
+	}
+	taste := tastes[userID]
+
+	// Shared artists and genres are only counted when a preference needs them
+	filter.CountSharedArtists = prefs.IsSet(models.PreferenceSharedArtists)
+	filter.CountSharedGenres = prefs.IsSet(models.PreferenceSharedGenres)
+	score := func(ids []uuid.UUID) ([]scoredCandidate, error) {
+		return h.scoreCandidates(ctx, filter, taste, prefs, hardAge, user.Location != nil, ids, now)
+	}
+
+	// Fill the page from the session's ranking, ranking the next pool of
+	// candidates whenever it runs out. Candidates are scored again when
+	// they are shown, and the ones no longer matching the filter are left out.
+	var candidates []scoredCandidate
+	offset := after.Offset
+	for pools := 0; len(candidates) < limit; {
+		if offset == len(session.RankedIDs) {
+			if session.RankedThrough == len(session.CandidateIDs) || pools == maxDiscoverPools {
+				break
+			}
+			end := session.RankedThrough + discoverPoolSize
+			if end > len(session.CandidateIDs) {
+				end = len(session.CandidateIDs)
+			}
+			pool, err := score(session.CandidateIDs[session.RankedThrough:end])
+			if err != nil {
+				fmt.Printf("[ERROR] Discover - Error scoring candidates for user %s: %v\n", userID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error loading discovery feed"})
+				return
+			}
+			if session, err = h.rankPool(ctx, session, pool, end); err != nil {
+				fmt.Printf("[ERROR] Discover - Error ranking candidates for user %s: %v\n", userID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error loading discovery feed"})
+				return
+			}
+			pools++
+			continue
+		}
+
+		end := offset + limit - len(candidates)
+		if end > len(session.RankedIDs) {
+			end = len(session.RankedIDs)
+		}
+		scored, err := score(session.RankedIDs[offset:end])
+		if err != nil {
+			fmt.Printf("[ERROR] Discover - Error scoring candidates for user %s: %v\n", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error loading discovery feed"})
+			return
+		}
+		candidates = append(candidates, scored...)
+		offset = end
+	}
+
+	var nextCursor *string
+	if offset < len(session.RankedIDs) || session.RankedThrough < len(session.CandidateIDs) {
+		cursor := encodeCursor(discoverCursor{Session: session.ID, Offset: offset})
+		nextCursor = &cursor
+	}
+
+	pageIDs := make([]uuid.UUID, len(candidates))
+	scored := make(map[uuid.UUID]scoredCandidate, len(candidates))
+	for i, candidate := range candidates {
+		pageIDs[i] = candidate.id
+		scored[candidate.id] = candidate
+	}
+
+	profiles, err := h.DB.GetUserProfiles(ctx, pageIDs)
+	if err != nil {
+		fmt.Printf("[ERROR] Discover - Error loading profiles for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error loading discovery feed"})
+		return
+	}
+
+	items := make([]gin.H, len(profiles))
+	for i, profile := range profiles {
+		items[i] = gin.H{
+			"profile":           profile.Public(now, user.Location),
+			"score":             scored[profile.ID].score,
+			"unmet_preferences": scored[profile.ID].unmet,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":       items,
+		"next_cursor": nextCursor,
+	})
+}
+
+// rankPool adds a pool of scored candidates to the session's ranking, best
+// ranked first, as the ranking of the candidates up to rankedThrough. When
+// another request ranked the pool first, the session is reloaded to use that
+// ranking instead.
+func (h *DiscoverHandler) rankPool(ctx context.Context, session *db.DiscoverySession, pool []scoredCandidate, rankedThrough int) (*db.DiscoverySession, error) {
+	sort.Slice(pool, func(i, j int) bool {
+		if pool[i].rank() != pool[j].rank() {
+			return pool[i].rank() > pool[j].rank()
+		}
+		return pool[i].id.String() < pool[j].id.String()
+	})
+
+	ranked := make([]uuid.UUID, len(pool))
+	for i, candidate := range pool {
+		ranked[i] = candidate.id
+	}
+	appended, err := h.DB.AppendDiscoveryRanking(ctx, session, ranked, rankedThrough)
+	if err != nil {
+		return nil, err
+	}
+	if appended {
+		return session, nil
+	}
+
+	reloaded, err := h.DB.GetDiscoverySession(ctx, session.ID, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if reloaded == nil {
+		return nil, fmt.Errorf("discovery session %s was deleted", session.ID)
+	}
+	return reloaded, nil
+}
+
+// scoreCandidates scores the candidates against the user's taste and
+// preferences in the given order, leaving out the ones that no longer match
+// the filter
+func (h *DiscoverHandler) scoreCandidates(ctx context.Context, filter db.DiscoveryFilter, taste *matching.Taste,
+	prefs *models.DiscoveryPreferences, hardAge, located bool, ids []uuid.UUID, now time.Time) ([]scoredCandidate, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	filter.IDs = ids
+	matches, err := h.DB.GetDiscoveryCandidates(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	matchingIDs := make([]uuid.UUID, len(matches))
+	byID := make(map[uuid.UUID]db.DiscoveryCandidate, len(matches))
+	for i, candidate := range matches {
+		matchingIDs[i] = candidate.ID
+		byID[candidate.ID] = candidate
+	}
+	tastes, err := matching.LoadTastes(ctx, h.DB, matchingIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]scoredCandidate, 0, len(matches))
+	for _, id := range ids {
+		candidate, ok := byID[id]
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, scoredCandidate{
+			id:    id,
+			score: matching.Score(taste, tastes[id]).Score,
+			unmet: unmetPreferences(prefs, hardAge, located, candidate, now),
+		})
+	}
+	return candidates, nil
+}
+
+// unmetPreferences lists the soft preferences the candidate misses.
+// Dealbreakers are already filtered on, and a candidate without a known height
+// or location misses a height or distance preference. The distance
+// preference only applies when the user is located.
+func unmetPreferences(prefs *models.DiscoveryPreferences, hardAge, located bool, candidate db.DiscoveryCandidate, now time.Time) []string {
+	unmet := []string{}
+	soft := func(field string) bool {
+		return prefs.IsSet(field) && !prefs.IsDealbreaker(field)
+	}
+
+	if soft(models.PreferenceAge) && !hardAge {
+		age := models.AgeAt(candidate.BirthdayInUnix, now)
+		if (prefs.MinAge != nil && age < *prefs.MinAge) || (prefs.MaxAge != nil && age > *prefs.MaxAge) {
+			unmet = append(unmet, models.PreferenceAge)
+		}
+	}
+	if soft(models.PreferenceHeight) {
+		height := candidate.HeightCM
+		if height == nil || (prefs.MinHeightCM != nil && *height < *prefs.MinHeightCM) ||
+			(prefs.MaxHeightCM != nil && *height > *prefs.MaxHeightCM) {
+			unmet = append(unmet, models.PreferenceHeight)
+		}
+	}
+	if soft(models.PreferenceDistance) && located &&
+		(candidate.DistanceKM == nil || *candidate.DistanceKM > float64(*prefs.MaxDistanceKM)) {
+		unmet = append(unmet, models.PreferenceDistance)
+	}
+	if soft(models.PreferenceSharedGenres) && candidate.SharedGenres < prefs.MinSharedGenres {
+		unmet = append(unmet, models.PreferenceSharedGenres)
+	}
+	if soft(models.PreferenceSharedArtists) && candidate.SharedArtists < prefs.MinSharedArtists {
+		unmet = append(unmet, models.PreferenceSharedArtists)
+	}
+	return unmet
+}
+
+// clampAge keeps an age within the supported range
+func clampAge(age int) int {
+	if age < minAge {
+		return minAge
+	}
+	if age > maxAge {
+		return maxAge
+	}
+	return age
+}
+
+// intQuery reads an integer query parameter between min and max, falling back
+// to defaultValue when it is missing
+func intQuery(c *gin.Context, key string, defaultValue, min, max int) (int, error) {
+	value := c.Query(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be between %d and %d", key, min, max)
+	}
+	return n, nil
+}
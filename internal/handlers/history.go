@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/middleware"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// HistoryHandler handles listening history requests
+type HistoryHandler struct {
+	DB *db.DB
+}
+
+// historyCursor is the position of the last play on a history page
+type historyCursor struct {
+	PlayedAt time.Time `json:"t"`
+	ID       uuid.UUID `json:"id"`
+}
+
+// GetHistory returns the user's listening history, newest first
+func (h *HistoryHandler) GetHistory(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	filter := db.HistoryFilter{Limit: defaultHistoryLimit}
+
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC 3339 timestamp"})
+			return
+		}
+		filter.From = t.UTC()
+	}
+
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC 3339 timestamp"})
+			return
+		}
+		filter.To = t.UTC()
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxHistoryLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit)})
+			return
+		}
+		filter.Limit = n
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		var position historyCursor
+		if err := decodeCursor(cursor, &position); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		filter.BeforePlayedAt = position.PlayedAt
+		filter.BeforeID = position.ID
+	}
+
+	// Fetch one extra play to find out whether there is another page
+	limit := filter.Limit
+	filter.Limit++
+
+	entries, err := h.DB.GetListeningHistory(ctx, userID, filter)
+	if err != nil {
+		fmt.Printf("[ERROR] GetHistory - Error retrieving history for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving listening history"})
+		return
+	}
+
+	var nextCursor *string
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[len(entries)-1]
+		cursor := encodeCursor(historyCursor{PlayedAt: last.PlayedAt, ID: last.ID})
+		nextCursor = &cursor
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":       entries,
+		"next_cursor": nextCursor,
+	})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/geo"
+	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// locationUpdateInterval is the minimum time between two location updates of a
+// user. Without it a user could move their own location around and narrow
+// down where someone else is from the distances they see.
+const locationUpdateInterval = 15 * time.Minute
+
+// UpdateLocationRequest represents a request to update the user's location
+type UpdateLocationRequest struct {
+	Latitude  *float64 `json:"lat"`
+	Longitude *float64 `json:"lng"`
+}
+
+// UpdateLocation stores where the user is. The coordinates are rounded to
+// about ten kilometers before they are stored, the precise location is never
+// kept.
+func (h *ProfileHandler) UpdateLocation(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req UpdateLocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	if req.Latitude == nil || req.Longitude == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lat and lng are required"})
+		return
+	}
+	if !geo.Valid(*req.Latitude, *req.Longitude) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lat must be between -90 and 90 and lng between -180 and 180"})
+		return
+	}
+
+	lat, lng := geo.Round(*req.Latitude), geo.Round(*req.Longitude)
+	location := &models.Location{
+		Latitude:  lat,
+		Longitude: lng,
+		Geohash:   geo.Geohash(lat, lng, geo.GeohashPrecision),
+	}
+	updated, err := h.DB.UpdateUserLocation(ctx, userID, location, locationUpdateInterval)
+	if err != nil {
+		fmt.Printf("[ERROR] UpdateLocation - Error saving location for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error updating location"})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "location was updated too recently"})
+		return
+	}
+
+	c.JSON(http.StatusOK, location)
+}
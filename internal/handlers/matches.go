@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/matching"
+	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/realtime"
+	"github.com/matchmyvibe/backend/internal/spotify"
+	"github.com/matchmyvibe/backend/internal/tokens"
+)
+
+const (
+	defaultMatchesLimit = 20
+	maxMatchesLimit     = 50
+)
+
+// MatchHandler handles requests about the user's matches
+type MatchHandler struct {
+	DB            *db.DB
+	Hub           *realtime.Hub
+	SpotifyClient *spotify.Client
+	Tokens        *tokens.Manager
+}
+
+// matchCursor is the position of the last match on a matches page
+type matchCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// ListMatches returns the user's matches, newest first, with the other user's
+// public profile, compatibility, last message and unread count
+func (h *MatchHandler) ListMatches(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	limit, err := intQuery(c, "limit", defaultMatchesLimit, 1, maxMatchesLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var after matchCursor
+	if cursor := c.Query("cursor"); cursor != "" {
+		if err := decodeCursor(cursor, &after); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+	}
+
+	// Fetch one extra match to find out whether there is another page
+	matches, err := h.DB.GetUserMatches(ctx, userID, after.CreatedAt, after.ID, limit+1)
+	if err != nil {
+		fmt.Printf("[ERROR] ListMatches - Error retrieving matches for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving matches"})
+		return
+	}
+
+	var nextCursor *string
+	if len(matches) > limit {
+		matches = matches[:limit]
+		last := matches[len(matches)-1]
+		cursor := encodeCursor(matchCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+		nextCursor = &cursor
+	}
+
+	otherIDs := make([]uuid.UUID, len(matches))
+	for i := range matches {
+		otherIDs[i] = matches[i].OtherUser(userID)
+	}
+
+	items, err := h.matchItems(ctx, userID, matches, otherIDs)
+	if err != nil {
+		fmt.Printf("[ERROR] ListMatches - Error loading matches for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving matches"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":       items,
+		"next_cursor": nextCursor,
+	})
+}
+
+// matchItems builds the response entries for matches, loading every other
+// user's profile and taste in bulk
+func (h *MatchHandler) matchItems(ctx context.Context, userID uuid.UUID, matches []models.Match, otherIDs []uuid.UUID) ([]gin.H, error) {
+	profiles, err := h.DB.GetUserProfiles(ctx, otherIDs)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]*models.UserProfile, len(profiles))
+	for _, profile := range profiles {
+		byID[profile.ID] = profile
+	}
+
+	tastes, err := matching.LoadTastes(ctx, h.DB, append([]uuid.UUID{userID}, otherIDs...))
+	if err != nil {
+		return nil, err
+	}
+
+	matchIDs := make([]uuid.UUID, len(matches))
+	for i := range matches {
+		matchIDs[i] = matches[i].ID
+	}
+	summaries, err := h.DB.GetMatchSummaries(ctx, userID, matchIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	viewerLocation, err := h.DB.GetUserLocation(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	items := make([]gin.H, 0, len(matches))
+	for i, match := range matches {
+		profile, ok := byID[otherIDs[i]]
+		if !ok {
+			continue
+		}
+		summary, ok := summaries[match.ID]
+		if !ok {
+			summary = &models.MatchSummary{}
+		}
+		items = append(items, gin.H{
+			"id":           match.ID,
+			"matched_at":   match.CreatedAt.Unix(),
+			"user":         profile.Public(now, viewerLocation),
+			"score":        matching.Score(tastes[userID], tastes[otherIDs[i]]).Score,
+			"last_message": summary.LastMessage,
+			"unread_count": summary.UnreadCount,
+		})
+	}
+	return items, nil
+}
+
+// Unmatch ends a match for both users. The conversation is hidden and the
+// pair never shows up in each other's discovery again.
+func (h *MatchHandler) Unmatch(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	matchID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid match id"})
+		return
+	}
+
+	unmatched, err := h.DB.Unmatch(ctx, matchID, userID)
+	if err != nil {
+		fmt.Printf("[ERROR] Unmatch - Error unmatching %s for user %s: %v\n", matchID, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error unmatching"})
+		return
+	}
+	if !unmatched {
+		c.JSON(http.StatusNotFound, gin.H{"error": "match not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unmatched": true})
+}
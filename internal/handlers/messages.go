@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/realtime"
+)
+
+const (
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 100
+)
+
+// SendMessageRequest represents a request to send a message. Type defaults to
+// text, which is the only type supported so far.
+type SendMessageRequest struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// MarkReadRequest represents a request to mark a conversation as read up to a message
+type MarkReadRequest struct {
+	Seq int64 `json:"seq"`
+}
+
+// messagesCursor is the position of the oldest message on a messages page
+type messagesCursor struct {
+	Seq int64 `json:"seq"`
+}
+
+// activeMatch loads the match in the :id parameter and checks that the user
+// is in it and it hasn't been unmatched. It writes the error response and
+// returns nil otherwise.
+func (h *MatchHandler) activeMatch(c *gin.Context, userID uuid.UUID) *models.Match {
+	matchID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid match id"})
+		return nil
+	}
+
+	match, err := h.DB.GetMatch(c.Request.Context(), matchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving match"})
+		return nil
+	}
+
+	// Don't tell other users whether a match exists
+	if match == nil || !match.Has(userID) || match.UnmatchedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "match not found"})
+		return nil
+	}
+
+	return match
+}
+
+// SendMessage sends a message to the other user of a match
+func (h *MatchHandler) SendMessage(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	match := h.activeMatch(c, userID)
+	if match == nil {
+		return
+	}
+
+	var req SendMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if req.Type == "" {
+		req.Type = models.MessageTypeText
+	}
+	if req.Type != models.MessageTypeText {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported message type"})
+		return
+	}
+
+	text := strings.TrimSpace(req.Text)
+	if text == "" || utf8.RuneCountInString(text) > models.MaxMessageTextLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("text must be between 1 and %d characters", models.MaxMessageTextLength)})
+		return
+	}
+
+	payload, err := json.Marshal(models.TextPayload{Text: text})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error sending message"})
+		return
+	}
+
+	message, err := h.DB.SendMessage(ctx, match.ID, userID, req.Type, payload)
+	if err != nil {
+		fmt.Printf("[ERROR] SendMessage - Error sending message in match %s: %v\n", match.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error sending message"})
+		return
+	}
+	if message == nil {
+		// The match ended after it was loaded
+		c.JSON(http.StatusNotFound, gin.H{"error": "match not found"})
+		return
+	}
+
+	// The sender's other devices get the message too
+	h.Hub.Publish(ctx, []uuid.UUID{match.UserA, match.UserB}, realtime.EventMessageCreated, message)
+
+	c.JSON(http.StatusCreated, message)
+}
+
+// GetMessages returns a match's messages, newest first
+func (h *MatchHandler) GetMessages(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	match := h.activeMatch(c, userID)
+	if match == nil {
+		return
+	}
+
+	limit, err := intQuery(c, "limit", defaultMessagesLimit, 1, maxMessagesLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var before messagesCursor
+	if cursor := c.Query("cursor"); cursor != "" {
+		if err := decodeCursor(cursor, &before); err != nil || before.Seq < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+	}
+
+	// Fetch one extra message to find out whether there is another page
+	messages, err := h.DB.GetMessages(ctx, match.ID, before.Seq, limit+1)
+	if err != nil {
+		fmt.Printf("[ERROR] GetMessages - Error retrieving messages in match %s: %v\n", match.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving messages"})
+		return
+	}
+
+	var nextCursor *string
+	if len(messages) > limit {
+		messages = messages[:limit]
+		cursor := encodeCursor(messagesCursor{Seq: messages[len(messages)-1].Seq})
+		nextCursor = &cursor
+	}
+
+	summaries, err := h.DB.GetMatchSummaries(ctx, userID, []uuid.UUID{match.ID})
+	if err != nil {
+		fmt.Printf("[ERROR] GetMessages - Error retrieving unread count in match %s: %v\n", match.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving messages"})
+		return
+	}
+
+	var unreadCount int64
+	if summary, ok := summaries[match.ID]; ok {
+		unreadCount = summary.UnreadCount
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":        messages,
+		"next_cursor":  nextCursor,
+		"unread_count": unreadCount,
+	})
+}
+
+// MarkRead marks a match's messages as read up to and including seq
+func (h *MatchHandler) MarkRead(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	match := h.activeMatch(c, userID)
+	if match == nil {
+		return
+	}
+
+	var req MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Seq < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "seq must be a positive message sequence number"})
+		return
+	}
+
+	if err := h.DB.MarkRead(ctx, match.ID, userID, req.Seq); err != nil {
+		fmt.Printf("[ERROR] MarkRead - Error marking match %s read for user %s: %v\n", match.ID, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error marking messages read"})
+		return
+	}
+
+	summaries, err := h.DB.GetMatchSummaries(ctx, userID, []uuid.UUID{match.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error marking messages read"})
+		return
+	}
+
+	var unreadCount int64
+	if summary, ok := summaries[match.ID]; ok {
+		unreadCount = summary.UnreadCount
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread_count": unreadCount})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// PreferencesHandler handles the discovery preferences of the user
+type PreferencesHandler struct {
+	DB *db.DB
+}
+
+// PreferencesRequest replaces all discovery preferences of the user. Missing
+// fields are unset.
+type PreferencesRequest struct {
+	MinAge           *int     `json:"min_age"`
+	MaxAge           *int     `json:"max_age"`
+	MaxDistanceKM    *int     `json:"max_distance_km"`
+	MinHeightCM      *int     `json:"min_height_cm"`
+	MaxHeightCM      *int     `json:"max_height_cm"`
+	MinSharedGenres  int      `json:"min_shared_genres"`
+	MinSharedArtists int      `json:"min_shared_artists"`
+	Dealbreakers     []string `json:"dealbreakers"`
+}
+
+// GetPreferences returns the user's discovery preferences, all unset if they
+// never saved any
+func (h *PreferencesHandler) GetPreferences(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	prefs, err := h.DB.GetDiscoveryPreferences(ctx, userID)
+	if err != nil {
+		fmt.Printf("[ERROR] GetPreferences - Error loading preferences for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving preferences"})
+		return
+	}
+	if prefs == nil {
+		prefs = &models.DiscoveryPreferences{Dealbreakers: []string{}}
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// UpdatePreferences validates and saves the user's discovery preferences
+func (h *PreferencesHandler) UpdatePreferences(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req PreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	prefs := &models.DiscoveryPreferences{
+		MinAge:           req.MinAge,
+		MaxAge:           req.MaxAge,
+		MaxDistanceKM:    req.MaxDistanceKM,
+		MinHeightCM:      req.MinHeightCM,
+		MaxHeightCM:      req.MaxHeightCM,
+		MinSharedGenres:  req.MinSharedGenres,
+		MinSharedArtists: req.MinSharedArtists,
+		Dealbreakers:     slices.Compact(slices.Sorted(slices.Values(req.Dealbreakers))),
+	}
+	if err := prefs.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.DB.SaveDiscoveryPreferences(ctx, userID, prefs); err != nil {
+		fmt.Printf("[ERROR] UpdatePreferences - Error saving preferences for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error saving preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
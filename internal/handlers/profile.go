@@ -10,28 +10,38 @@ import (
 	"github.com/matchmyvibe/backend/internal/db"
 	"github.com/matchmyvibe/backend/internal/middleware"
 	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/playback"
 	"github.com/matchmyvibe/backend/internal/spotify"
+	"github.com/matchmyvibe/backend/internal/tokens"
 )
 
 // ProfileHandler handles profile-related requests
 type ProfileHandler struct {
 	DB            *db.DB
 	SpotifyClient *spotify.Client
+	Tokens        *tokens.Manager
+	Notifier      *playback.Notifier
 }
 
 // GetProfile retrieves the user's profile
 func (h *ProfileHandler) GetProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	userID := middleware.GetUserID(c)
 	if userID == uuid.Nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	profile, err := h.DB.GetFullUserProfile(userID)
+	profile, err := h.DB.GetFullUserProfile(ctx, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user profile"})
 		return
 	}
+	if profile == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, profile)
 }
@@ -58,6 +68,8 @@ type UpdateProfileRequest struct {
 
 // UpdateProfile updates the user's profile
 func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	userID := middleware.GetUserID(c)
 	if userID == uuid.Nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -76,7 +88,7 @@ func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
 		req.BirthdayInUnix, req.Gender, req.DatingPreference)
 
 	// Get the current user to update
-	user, err := h.DB.GetUserByID(userID)
+	user, err := h.DB.GetUserByID(ctx, userID)
 	if err != nil {
 		fmt.Printf("[ERROR] UpdateProfile - Error retrieving user: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
@@ -131,7 +143,7 @@ func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
 		user.BirthdayInUnix, user.Gender, user.DatingPreference)
 
 	// Update the user in the database
-	if err := h.DB.UpdateUser(user); err != nil {
+	if err := h.DB.UpdateUser(ctx, user); err != nil {
 		fmt.Printf("[ERROR] UpdateProfile - Error updating user: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error updating user"})
 		return
@@ -140,13 +152,13 @@ func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
 	// Update images if provided
 	if req.Images != nil {
 		// Clear existing images and add new ones
-		if err := h.DB.ClearUserImages(userID); err != nil {
+		if err := h.DB.ClearUserImages(ctx, userID); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error clearing user images"})
 			return
 		}
 
 		for _, imageData := range req.Images {
-			if err := h.DB.SaveImage(userID, imageData); err != nil {
+			if err := h.DB.SaveImage(ctx, userID, imageData); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "error saving image"})
 				return
 			}
@@ -156,13 +168,13 @@ func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
 	// Update interests if provided
 	if req.Interests != nil {
 		// Clear existing interests and add new ones
-		if err := h.DB.ClearUserInterests(userID); err != nil {
+		if err := h.DB.ClearUserInterests(ctx, userID); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error clearing user interests"})
 			return
 		}
 
 		for _, interest := range req.Interests {
-			if err := h.DB.SaveInterest(userID, interest); err != nil {
+			if err := h.DB.SaveInterest(ctx, userID, interest); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "error saving interest"})
 				return
 			}
@@ -172,13 +184,13 @@ func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
 	// Update interest ratings if provided
 	if req.InterestRating != nil {
 		// Clear existing interest ratings and add new ones
-		if err := h.DB.ClearUserInterestRatings(userID); err != nil {
+		if err := h.DB.ClearUserInterestRatings(ctx, userID); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error clearing user interest ratings"})
 			return
 		}
 
 		for interest, rating := range req.InterestRating {
-			if err := h.DB.SaveInterestRating(userID, interest, rating); err != nil {
+			if err := h.DB.SaveInterestRating(ctx, userID, interest, rating); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "error saving interest rating"})
 				return
 			}
@@ -188,13 +200,13 @@ func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
 	// Update prompts if provided
 	if req.Prompts != nil {
 		// Clear existing prompts and add new ones
-		if err := h.DB.ClearUserPrompts(userID); err != nil {
+		if err := h.DB.ClearUserPrompts(ctx, userID); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error clearing user prompts"})
 			return
 		}
 
 		for _, prompt := range req.Prompts {
-			if err := h.DB.SavePrompt(userID, prompt.Question, prompt.Answer); err != nil {
+			if err := h.DB.SavePrompt(ctx, userID, prompt.Question, prompt.Answer); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "error saving prompt"})
 				return
 			}
@@ -202,7 +214,7 @@ func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
 	}
 
 	// Get the updated profile
-	profile, err := h.DB.GetFullUserProfile(userID)
+	profile, err := h.DB.GetFullUserProfile(ctx, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving updated user profile"})
 		return
@@ -213,18 +225,26 @@ func (h *ProfileHandler) UpdateProfile(c *gin.Context) {
 
 // UpdateCurrentlyPlayingRequest represents the request body for updating the user's currently playing track
 type UpdateCurrentlyPlayingRequest struct {
-	Track        string `json:"track"`
-	Artist       string `json:"artist"`
-	URI          string `json:"uri"`
-	Album        string `json:"album"`
-	AlbumURI     string `json:"album_uri"`
-	Duration     int    `json:"duration"`
-	ContextTitle string `json:"context_title"`
-	ContextURI   string `json:"context_uri"`
+	Type         string   `json:"type"`
+	Track        string   `json:"track"`
+	Artist       string   `json:"artist"`
+	ArtistURIs   []string `json:"artist_uris"`
+	URI          string   `json:"uri"`
+	Album        string   `json:"album"`
+	AlbumURI     string   `json:"album_uri"`
+	ImageURL     string   `json:"image_url"`
+	Duration     int      `json:"duration"`
+	ProgressMS   int      `json:"progress_ms"`
+	IsPlaying    *bool    `json:"is_playing"`
+	ContextTitle string   `json:"context_title"`
+	ContextURI   string   `json:"context_uri"`
+	ContextType  string   `json:"context_type"`
 }
 
 // UpdateCurrentlyPlaying updates the user's currently playing track
 func (h *ProfileHandler) UpdateCurrentlyPlaying(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	userID := middleware.GetUserID(c)
 	if userID == uuid.Nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -232,7 +252,7 @@ func (h *ProfileHandler) UpdateCurrentlyPlaying(c *gin.Context) {
 	}
 
 	// Get the user
-	user, err := h.DB.GetUserByID(userID)
+	user, err := h.DB.GetUserByID(ctx, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
 		return
@@ -246,86 +266,85 @@ func (h *ProfileHandler) UpdateCurrentlyPlaying(c *gin.Context) {
 		return
 	}
 
-	// Create formatted currently playing string
-	currentlyPlaying := fmt.Sprintf("%s - %s", req.Track, req.Artist)
-	user.CurrentlyPlaying = &currentlyPlaying
+	if req.Type != "" && req.Type != models.PlayingTypeTrack && req.Type != models.PlayingTypeEpisode {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid type value"})
+		return
+	}
+
+	// Older clients don't send is_playing, they only report tracks that are playing
+	isPlaying := true
+	if req.IsPlaying != nil {
+		isPlaying = *req.IsPlaying
+	}
 
 	// Create last played song object
 	lastPlayedSong := &models.LastPlayedSong{
+		Type:         req.Type,
 		Track:        req.Track,
 		Artist:       req.Artist,
+		ArtistURIs:   req.ArtistURIs,
 		URI:          req.URI,
 		Album:        req.Album,
 		AlbumURI:     req.AlbumURI,
+		ImageURL:     req.ImageURL,
 		Duration:     req.Duration,
+		ProgressMS:   req.ProgressMS,
+		IsPlaying:    isPlaying,
 		ContextTitle: req.ContextTitle,
 		ContextURI:   req.ContextURI,
-	}
-	user.LastPlayedSong = lastPlayedSong
-
-	// Update user's last active timestamp
-	now := time.Now().Unix()
-	user.UserLastActiveAt = &now
-
-	// Update the user in the database
-	if err := h.DB.UpdateUser(user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error updating user"})
-		return
+		ContextType:  req.ContextType,
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"currently_playing":   currentlyPlaying,
-		"last_played_song":    lastPlayedSong,
-		"user_last_active_at": now,
-	})
+	saveCurrentlyPlaying(c, h, user, lastPlayedSong)
 }
 
 // handleSpotifyCurrentlyPlaying is the legacy function to fetch currently playing from Spotify
 func handleSpotifyCurrentlyPlaying(c *gin.Context, h *ProfileHandler, user *models.User) {
-	// Check if the access token needs to be refreshed
-	if time.Now().After(user.TokenExpiry) {
-		// Refresh the token
-		tokenResponse, err := h.SpotifyClient.RefreshToken(user.RefreshToken)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error refreshing Spotify token"})
-			return
-		}
-
-		// Calculate the new expiry time
-		newExpiry := time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
-
-		// Update the user's tokens in the database
-		err = h.DB.UpdateSpotifyTokens(user.ID, tokenResponse.AccessToken, tokenResponse.RefreshToken, newExpiry)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error updating user tokens"})
-			return
-		}
+	ctx := c.Request.Context()
 
-		// Update the access token for the request
-		user.AccessToken = tokenResponse.AccessToken
+	// Get a valid access token, refreshing it if needed
+	accessToken, err := h.Tokens.AccessToken(ctx, user)
+	if err != nil {
+		respondSpotifyError(c, err, "error refreshing Spotify token")
+		return
 	}
 
-	// Get the currently playing track from Spotify
-	currentlyPlaying, err := h.SpotifyClient.GetCurrentlyPlaying(user.AccessToken)
+	// Get the playback state from Spotify
+	currentlyPlaying, err := h.SpotifyClient.GetCurrentlyPlaying(ctx, accessToken)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error fetching currently playing track"})
+		respondSpotifyError(c, err, "error fetching currently playing track")
 		return
 	}
 
-	// Update the user's currently playing track in the database
-	user.CurrentlyPlaying = &currentlyPlaying
+	saveCurrentlyPlaying(c, h, user, playback.SongFromSpotify(currentlyPlaying))
+}
+
+// saveCurrentlyPlaying stores the song on the user and writes the response.
+// Both the client-pushed and the Spotify-fetched path end here so they store
+// the same shape. A nil song means nothing is playing.
+func saveCurrentlyPlaying(c *gin.Context, h *ProfileHandler, user *models.User, song *models.LastPlayedSong) {
+	ctx := c.Request.Context()
+
+	changed := playback.Changed(user, song)
+	currentlyPlaying := playback.Apply(user, song)
 
 	// Update user's last active timestamp
 	now := time.Now().Unix()
 	user.UserLastActiveAt = &now
 
-	if err := h.DB.UpdateUser(user); err != nil {
+	// Update the user in the database
+	if err := h.DB.UpdateUser(ctx, user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error updating currently playing track"})
 		return
 	}
 
+	if changed {
+		h.Notifier.SongChanged(ctx, user)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"currently_playing":   currentlyPlaying,
+		"last_played_song":    user.LastPlayedSong,
 		"user_last_active_at": now,
 	})
 }
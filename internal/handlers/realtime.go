@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/realtime"
+	"golang.org/x/net/websocket"
+)
+
+// typingInterval is the minimum time between typing events forwarded from
+// one connection for the same match
+const typingInterval = 2 * time.Second
+
+// RealtimeHandler handles the WebSocket connection clients receive real-time
+// events on
+type RealtimeHandler struct {
+	DB  *db.DB
+	Hub *realtime.Hub
+}
+
+// Connect upgrades the request to a WebSocket connection that receives the
+// user's events until either side closes it
+func (h *RealtimeHandler) Connect(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	expiresAt := middleware.GetTokenExpiry(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if h.Hub == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "real-time events are unavailable"})
+		return
+	}
+
+	server := websocket.Server{
+		// Connections are authenticated with the JWT rather than cookies, so a
+		// page on another origin can't open one on behalf of the user
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			// Messages of one connection are handled one at a time
+			lastTyping := make(map[uuid.UUID]time.Time)
+			h.Hub.Serve(conn, userID, expiresAt, func(ctx context.Context, userID uuid.UUID, msg realtime.Inbound) {
+				if msg.Type == realtime.EventTyping {
+					h.forwardTyping(ctx, userID, msg.Data, lastTyping)
+				}
+			})
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// forwardTyping tells the other user of an active match that the user is
+// typing. Unknown matches and matches the user isn't in are ignored.
+func (h *RealtimeHandler) forwardTyping(ctx context.Context, userID uuid.UUID, data json.RawMessage, lastTyping map[uuid.UUID]time.Time) {
+	var typing realtime.TypingData
+	if err := json.Unmarshal(data, &typing); err != nil || typing.MatchID == uuid.Nil {
+		return
+	}
+
+	if time.Since(lastTyping[typing.MatchID]) < typingInterval {
+		return
+	}
+	lastTyping[typing.MatchID] = time.Now()
+
+	match, err := h.DB.GetMatch(ctx, typing.MatchID)
+	if err != nil {
+		fmt.Printf("[ERROR] forwardTyping - Error retrieving match %s: %v\n", typing.MatchID, err)
+		return
+	}
+	if match == nil || !match.Has(userID) || match.UnmatchedAt != nil {
+		return
+	}
+
+	h.Hub.Publish(ctx, []uuid.UUID{match.OtherUser(userID)}, realtime.EventTyping, realtime.TypingData{
+		MatchID: match.ID,
+		UserID:  userID,
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// ReportRequest represents a request to report another user. Details are
+// required when the reason is other.
+type ReportRequest struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+// Block blocks another user. They disappear from each other's discovery,
+// profiles and matches, and any match between them ends.
+func (h *UserHandler) Block(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	blockedID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	if blockedID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot block yourself"})
+		return
+	}
+
+	blocked, err := h.DB.GetUserByID(ctx, blockedID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
+		return
+	}
+	if blocked == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if err := h.DB.Block(ctx, userID, blockedID); err != nil {
+		fmt.Printf("[ERROR] Block - Error saving block from %s on %s: %v\n", userID, blockedID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error blocking user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blocked": true})
+}
+
+// Report reports another user for moderation review
+func (h *UserHandler) Report(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	reportedID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	if reportedID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot report yourself"})
+		return
+	}
+
+	var req ReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if !slices.Contains(models.ReportReasons, req.Reason) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be one of " + strings.Join(models.ReportReasons, ", ")})
+		return
+	}
+
+	details := strings.TrimSpace(req.Details)
+	if utf8.RuneCountInString(details) > models.MaxReportDetailsLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("details must be at most %d characters", models.MaxReportDetailsLength)})
+		return
+	}
+	if details == "" && req.Reason == models.ReportReasonOther {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "details are required when the reason is other"})
+		return
+	}
+
+	// Users who blocked the reporter can still be reported
+	reported, err := h.DB.GetUserByID(ctx, reportedID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
+		return
+	}
+	if reported == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	report := &models.Report{
+		ReporterID: userID,
+		ReportedID: reportedID,
+		Reason:     req.Reason,
+	}
+	if details != "" {
+		report.Details = &details
+	}
+
+	if err := h.DB.CreateReport(ctx, report); err != nil {
+		fmt.Printf("[ERROR] Report - Error saving report from %s on %s: %v\n", userID, reportedID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error reporting user"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, report)
+}
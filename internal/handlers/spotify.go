@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/musicsync"
+	"github.com/matchmyvibe/backend/internal/spotify"
+	"github.com/matchmyvibe/backend/internal/tokens"
+)
+
+// SpotifyHandler handles requests that pull data from Spotify
+type SpotifyHandler struct {
+	DB        *db.DB
+	MusicSync *musicsync.Service
+}
+
+// Sync imports the user's top artists and songs from Spotify unless they were
+// synced recently
+func (h *SpotifyHandler) Sync(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	user, err := h.DB.GetUserByID(ctx, userID)
+	if err != nil || user == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
+		return
+	}
+
+	synced, syncedAt, err := h.MusicSync.SyncIfStale(ctx, user)
+	if err != nil {
+		fmt.Printf("[ERROR] Sync - Error syncing Spotify data for user %s: %v\n", userID, err)
+		respondSpotifyError(c, err, "error syncing Spotify data")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"synced":         synced,
+		"last_synced_at": syncedAt.Unix(),
+	})
+}
+
+// respondSpotifyError writes the response for a failed Spotify call, telling
+// the client whether to log in again, grant more scopes or retry later
+func respondSpotifyError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, tokens.ErrNeedsReauth), errors.Is(err, spotify.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "spotify authorization expired, please log in again"})
+	case errors.Is(err, spotify.ErrScopeMissing):
+		c.JSON(http.StatusForbidden, gin.H{"error": "spotify permissions missing, please log in again"})
+	case errors.Is(err, spotify.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "spotify refused the request for this account"})
+	case errors.Is(err, spotify.ErrRateLimited):
+		if retryAfter := spotify.RetryAfter(err); retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "spotify is busy, please try again later"})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": message})
+	}
+}
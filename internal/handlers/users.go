@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/matching"
+	"github.com/matchmyvibe/backend/internal/middleware"
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/realtime"
+)
+
+// UserHandler handles requests about other users
+type UserHandler struct {
+	DB  *db.DB
+	Hub *realtime.Hub
+}
+
+// GetUser returns another user's public profile
+func (h *UserHandler) GetUser(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	otherID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	if !h.notBlocked(c, userID, otherID) {
+		return
+	}
+
+	profile, err := h.DB.GetFullUserProfile(ctx, otherID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user profile"})
+		return
+	}
+	if profile == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	viewerLocation, err := h.DB.GetUserLocation(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile.Public(time.Now(), viewerLocation))
+}
+
+// notBlocked checks that neither user blocked the other. Blocked users look
+// like they don't exist, so it writes a 404 and returns false otherwise.
+func (h *UserHandler) notBlocked(c *gin.Context, userID, otherID uuid.UUID) bool {
+	blocked, err := h.DB.IsBlocked(c.Request.Context(), userID, otherID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
+		return false
+	}
+	if blocked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return false
+	}
+	return true
+}
+
+// Like likes another user and reports whether it created a match
+func (h *UserHandler) Like(c *gin.Context) {
+	h.swipe(c, models.SwipeLike)
+}
+
+// Pass passes on another user so they are no longer shown in discovery
+func (h *UserHandler) Pass(c *gin.Context) {
+	h.swipe(c, models.SwipePass)
+}
+
+func (h *UserHandler) swipe(c *gin.Context, action string) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	targetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	if targetID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot swipe on yourself"})
+		return
+	}
+
+	target, err := h.DB.GetUserByID(ctx, targetID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
+		return
+	}
+	if target == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if !h.notBlocked(c, userID, targetID) {
+		return
+	}
+
+	match, err := h.DB.Swipe(ctx, userID, targetID, action)
+	if err != nil {
+		fmt.Printf("[ERROR] swipe - Error saving %s from %s on %s: %v\n", action, userID, targetID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error saving swipe"})
+		return
+	}
+
+	if match == nil {
+		c.JSON(http.StatusOK, gin.H{"matched": false})
+		return
+	}
+
+	// Tell both users, each event names the other user of the match
+	for _, memberID := range []uuid.UUID{match.UserA, match.UserB} {
+		h.Hub.Publish(ctx, []uuid.UUID{memberID}, realtime.EventMatchCreated, gin.H{
+			"match_id":   match.ID,
+			"user_id":    match.OtherUser(memberID),
+			"created_at": match.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"matched":  true,
+		"match_id": match.ID,
+	})
+}
+
+// Compatibility scores how well the user's music taste fits another user's
+func (h *UserHandler) Compatibility(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userID := middleware.GetUserID(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	otherID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	if otherID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot score compatibility with yourself"})
+		return
+	}
+
+	other, err := h.DB.GetUserByID(ctx, otherID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error retrieving user"})
+		return
+	}
+	if other == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if !h.notBlocked(c, userID, otherID) {
+		return
+	}
+
+	taste, err := matching.LoadTaste(ctx, h.DB, userID)
+	if err != nil {
+		fmt.Printf("[ERROR] Compatibility - Error loading taste for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error scoring compatibility"})
+		return
+	}
+
+	otherTaste, err := matching.LoadTaste(ctx, h.DB, otherID)
+	if err != nil {
+		fmt.Printf("[ERROR] Compatibility - Error loading taste for user %s: %v\n", otherID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error scoring compatibility"})
+		return
+	}
+
+	result := matching.Score(taste, otherTaste)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":   otherID,
+		"score":     result.Score,
+		"breakdown": result.Breakdown,
+	})
+}
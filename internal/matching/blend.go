@@ -0,0 +1,71 @@
+package matching
+
+import (
+	"sort"
+
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// Blend mixes two users' top songs into one playlist of at most limit songs.
+// Songs both users have come first, ordered by their combined rank weight.
+// The rest are interleaved by rank weight, so both users' best songs come
+// early and ties alternate between the users, starting with a. Songs without
+// a URI and duplicates are skipped.
+func Blend(a, b []models.Song, limit int) []models.Song {
+	weightsA := rankedSongs(a)
+	weightsB := rankedSongs(b)
+
+	var both []models.Song
+	for _, song := range a {
+		if _, ok := weightsB[song.Uri]; ok && song.Uri != "" {
+			both = append(both, song)
+		}
+	}
+	sort.SliceStable(both, func(i, j int) bool {
+		return weightsA[both[i].Uri]+weightsB[both[i].Uri] > weightsA[both[j].Uri]+weightsB[both[j].Uri]
+	})
+
+	blend := make([]models.Song, 0, limit)
+	seen := make(map[string]bool)
+	add := func(song models.Song) {
+		if len(blend) < limit && !seen[song.Uri] {
+			seen[song.Uri] = true
+			blend = append(blend, song)
+		}
+	}
+
+	for _, song := range both {
+		add(song)
+	}
+
+	// next skips songs that can't be added and returns the index of the next
+	// candidate in songs
+	next := func(songs []models.Song, i int) int {
+		for i < len(songs) && (songs[i].Uri == "" || seen[songs[i].Uri]) {
+			i++
+		}
+		return i
+	}
+
+	i, j := next(a, 0), next(b, 0)
+	turnA := true
+	for len(blend) < limit && (i < len(a) || j < len(b)) {
+		takeA := j >= len(b)
+		if i < len(a) && j < len(b) {
+			weightA, weightB := rankWeight(a[i].Rank), rankWeight(b[j].Rank)
+			takeA = weightA > weightB || (weightA == weightB && turnA)
+			if weightA == weightB {
+				turnA = !turnA
+			}
+		}
+
+		if takeA {
+			add(a[i])
+		} else {
+			add(b[j])
+		}
+		i, j = next(a, i), next(b, j)
+	}
+
+	return blend
+}
@@ -0,0 +1,317 @@
+// Package matching scores how well two users' music tastes fit together
+package matching
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// Component weights in the overall score. Components one of the users has no
+// data for are left out and the others scaled up.
+const (
+	artistsWeight   = 0.30
+	genresWeight    = 0.20
+	songsWeight     = 0.15
+	vibeWeight      = 0.15
+	interestsWeight = 0.10
+	playlistsWeight = 0.10
+)
+
+// maxShared is how many shared artists and songs a breakdown lists
+const maxShared = 5
+
+// maxTempo is the tempo in BPM that vibe vectors are normalized against
+const maxTempo = 200.0
+
+// Taste is everything about a user that compatibility is scored from
+type Taste struct {
+	UserID         uuid.UUID
+	Artists        []models.Artist
+	Songs          []models.Song
+	Playlists      []models.Playlist
+	Genres         []models.GenreShare
+	VibeVector     *models.VibeVector
+	InterestRating map[string]int
+}
+
+// Breakdown explains a score. Every component ranges from 0 to 100 and is
+// nil when one of the users has no data for it.
+type Breakdown struct {
+	Artists       *float64 `json:"artists"`
+	Songs         *float64 `json:"songs"`
+	Playlists     *float64 `json:"playlists"`
+	Genres        *float64 `json:"genres"`
+	Vibe          *float64 `json:"vibe"`
+	Interests     *float64 `json:"interests"`
+	SharedArtists []string `json:"shared_artists"`
+	SharedSongs   []string `json:"shared_songs"`
+	SharedGenres  []string `json:"shared_genres"`
+}
+
+// Result is the compatibility of two users
+type Result struct {
+	Score     int       `json:"score"`
+	Breakdown Breakdown `json:"breakdown"`
+}
+
+// LoadTaste loads a user's taste from the database
+func LoadTaste(ctx context.Context, database *db.DB, userID uuid.UUID) (*Taste, error) {
+	taste := &Taste{UserID: userID}
+	var err error
+
+	if taste.Artists, err = database.GetUserArtists(ctx, userID); err != nil {
+		return nil, err
+	}
+	if taste.Songs, err = database.GetUserSongs(ctx, userID); err != nil {
+		return nil, err
+	}
+	if taste.Playlists, err = database.GetUserPlaylists(ctx, userID); err != nil {
+		return nil, err
+	}
+	if taste.Genres, err = database.GetUserGenres(ctx, userID); err != nil {
+		return nil, err
+	}
+	if taste.VibeVector, err = database.GetUserVibeVector(ctx, userID); err != nil {
+		return nil, err
+	}
+	if taste.InterestRating, err = database.GetUserInterestRatings(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return taste, nil
+}
+
+// LoadTastes loads the tastes of several users with one query per table
+func LoadTastes(ctx context.Context, database *db.DB, userIDs []uuid.UUID) (map[uuid.UUID]*Taste, error) {
+	artists, err := database.GetArtistsForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	songs, err := database.GetSongsForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	playlists, err := database.GetPlaylistsForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	genres, err := database.GetGenresForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := database.GetVibeVectorsForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	ratings, err := database.GetInterestRatingsForUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	tastes := make(map[uuid.UUID]*Taste, len(userIDs))
+	for _, id := range userIDs {
+		tastes[id] = &Taste{
+			UserID:         id,
+			Artists:        artists[id],
+			Songs:          songs[id],
+			Playlists:      playlists[id],
+			Genres:         genres[id],
+			VibeVector:     vectors[id],
+			InterestRating: ratings[id],
+		}
+	}
+	return tastes, nil
+}
+
+// Score scores the compatibility of two users from 0 to 100. It is symmetric.
+func Score(a, b *Taste) Result {
+	var result Result
+	total, weights := 0.0, 0.0
+	add := func(component **float64, weight float64, similarity float64, ok bool) {
+		if !ok {
+			return
+		}
+		value := math.Round(similarity * 100)
+		*component = &value
+		total += weight * similarity
+		weights += weight
+	}
+
+	artistsA, artistsB := rankedArtists(a.Artists), rankedArtists(b.Artists)
+	songsA, songsB := rankedSongs(a.Songs), rankedSongs(b.Songs)
+	genresA, genresB := genreShares(a.Genres), genreShares(b.Genres)
+
+	// Top lists rarely overlap much, the square root spreads small overlaps out
+	add(&result.Breakdown.Artists, artistsWeight, math.Sqrt(cosine(artistsA, artistsB)), len(artistsA) > 0 && len(artistsB) > 0)
+	add(&result.Breakdown.Songs, songsWeight, math.Sqrt(cosine(songsA, songsB)), len(songsA) > 0 && len(songsB) > 0)
+	add(&result.Breakdown.Genres, genresWeight, cosine(genresA, genresB), len(genresA) > 0 && len(genresB) > 0)
+	add(&result.Breakdown.Playlists, playlistsWeight, math.Sqrt(jaccard(a.Playlists, b.Playlists)), len(a.Playlists) > 0 && len(b.Playlists) > 0)
+	add(&result.Breakdown.Interests, interestsWeight, cosine(ratings(a.InterestRating), ratings(b.InterestRating)), len(a.InterestRating) > 0 && len(b.InterestRating) > 0)
+	if a.VibeVector != nil && b.VibeVector != nil {
+		add(&result.Breakdown.Vibe, vibeWeight, vibeSimilarity(a.VibeVector, b.VibeVector), true)
+	}
+
+	if weights > 0 {
+		result.Score = int(math.Round(100 * total / weights))
+	}
+
+	result.Breakdown.SharedArtists = shared(artistsA, artistsB, artistNames(a.Artists))
+	result.Breakdown.SharedSongs = shared(songsA, songsB, songNames(a.Songs))
+	result.Breakdown.SharedGenres = shared(genresA, genresB, nil)
+	return result
+}
+
+// rankWeight makes higher ranked items count more, like discounted
+// cumulative gain. Unranked items count as rank 1.
+func rankWeight(rank int) float64 {
+	if rank < 1 {
+		rank = 1
+	}
+	return 1 / math.Log2(float64(rank)+1)
+}
+
+func rankedArtists(artists []models.Artist) map[string]float64 {
+	weights := make(map[string]float64, len(artists))
+	for _, artist := range artists {
+		weights[artist.Uri] = rankWeight(artist.Rank)
+	}
+	return weights
+}
+
+func rankedSongs(songs []models.Song) map[string]float64 {
+	weights := make(map[string]float64, len(songs))
+	for _, song := range songs {
+		weights[song.Uri] = rankWeight(song.Rank)
+	}
+	return weights
+}
+
+func genreShares(genres []models.GenreShare) map[string]float64 {
+	weights := make(map[string]float64, len(genres))
+	for _, genre := range genres {
+		weights[genre.Genre] = genre.Share
+	}
+	return weights
+}
+
+func ratings(interestRating map[string]int) map[string]float64 {
+	weights := make(map[string]float64, len(interestRating))
+	for name, rating := range interestRating {
+		weights[name] = float64(rating)
+	}
+	return weights
+}
+
+func artistNames(artists []models.Artist) map[string]string {
+	names := make(map[string]string, len(artists))
+	for _, artist := range artists {
+		names[artist.Uri] = artist.Name
+	}
+	return names
+}
+
+func songNames(songs []models.Song) map[string]string {
+	names := make(map[string]string, len(songs))
+	for _, song := range songs {
+		names[song.Uri] = song.Name + " - " + song.Artist
+	}
+	return names
+}
+
+// cosine returns the cosine similarity of two sparse vectors, clamped to 0..1
+func cosine(a, b map[string]float64) float64 {
+	dot, normA, normB := 0.0, 0.0, 0.0
+	for key, x := range a {
+		normA += x * x
+		dot += x * b[key]
+	}
+	for _, y := range b {
+		normB += y * y
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(1, dot/math.Sqrt(normA*normB)))
+}
+
+// jaccard returns the share of playlists saved by both users
+func jaccard(a, b []models.Playlist) float64 {
+	uris := make(map[string]bool, len(a))
+	for _, playlist := range a {
+		uris[playlist.Uri] = true
+	}
+
+	both, union := 0, len(uris)
+	seen := make(map[string]bool, len(b))
+	for _, playlist := range b {
+		if seen[playlist.Uri] {
+			continue
+		}
+		seen[playlist.Uri] = true
+		if uris[playlist.Uri] {
+			both++
+		} else {
+			union++
+		}
+	}
+	if union == 0 {
+		return 0
+	}
+	return float64(both) / float64(union)
+}
+
+// vibeSimilarity is one minus the mean absolute difference of the features
+func vibeSimilarity(a, b *models.VibeVector) float64 {
+	diffs := []float64{
+		a.Energy - b.Energy,
+		a.Valence - b.Valence,
+		a.Danceability - b.Danceability,
+		a.Acousticness - b.Acousticness,
+		a.Instrumentalness - b.Instrumentalness,
+		math.Min(a.Tempo, maxTempo)/maxTempo - math.Min(b.Tempo, maxTempo)/maxTempo,
+	}
+
+	sum := 0.0
+	for _, diff := range diffs {
+		sum += math.Abs(diff)
+	}
+	return math.Max(0, 1-sum/float64(len(diffs)))
+}
+
+// shared lists the keys in both vectors, strongest combined weight first,
+// using names for display when given
+func shared(a, b map[string]float64, names map[string]string) []string {
+	type item struct {
+		key    string
+		weight float64
+	}
+	var items []item
+	for key, x := range a {
+		if y, ok := b[key]; ok {
+			items = append(items, item{key, x * y})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].weight != items[j].weight {
+			return items[i].weight > items[j].weight
+		}
+		return items[i].key < items[j].key
+	})
+
+	if len(items) > maxShared {
+		items = items[:maxShared]
+	}
+	out := make([]string, len(items))
+	for i, it := range items {
+		out[i] = it.key
+		if name, ok := names[it.key]; ok {
+			out[i] = name
+		}
+	}
+	return out
+}
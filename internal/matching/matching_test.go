@@ -0,0 +1,215 @@
+package matching
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/models"
+)
+
+// fixtureArtists returns artists named and ranked in order, with URIs built
+// from their names
+func fixtureArtists(names ...string) []models.Artist {
+	artists := make([]models.Artist, len(names))
+	for i, name := range names {
+		artists[i] = models.Artist{Name: name, Uri: "spotify:artist:" + name, Rank: i + 1}
+	}
+	return artists
+}
+
+// fixtureSongs returns songs named and ranked in order, with URIs built from
+// their names
+func fixtureSongs(names ...string) []models.Song {
+	songs := make([]models.Song, len(names))
+	for i, name := range names {
+		songs[i] = models.Song{Name: name, Artist: "Artist", Uri: "spotify:track:" + name, Rank: i + 1}
+	}
+	return songs
+}
+
+// fixturePlaylists returns playlists with URIs built from the given ids
+func fixturePlaylists(ids ...string) []models.Playlist {
+	playlists := make([]models.Playlist, len(ids))
+	for i, id := range ids {
+		playlists[i] = models.Playlist{Name: id, Uri: "spotify:playlist:" + id}
+	}
+	return playlists
+}
+
+// indiePopFan and metalFan have no taste in common at all
+func indiePopFan() *Taste {
+	return &Taste{
+		UserID:    uuid.New(),
+		Artists:   fixtureArtists("phoebe", "clairo", "beabadoobee"),
+		Songs:     fixtureSongs("motion-sickness", "sofia", "coffee"),
+		Playlists: fixturePlaylists("indie-mix", "bedroom-pop"),
+		Genres: []models.GenreShare{
+			{Genre: "indie pop", Share: 0.6},
+			{Genre: "bedroom pop", Share: 0.4},
+		},
+		VibeVector: &models.VibeVector{
+			Energy: 1, Valence: 1, Danceability: 1, Acousticness: 1, Instrumentalness: 1, Tempo: maxTempo,
+		},
+		InterestRating: map[string]int{"concerts": 5, "vinyl": 4},
+	}
+}
+
+func metalFan() *Taste {
+	return &Taste{
+		UserID:     uuid.New(),
+		Artists:    fixtureArtists("metallica", "slayer", "megadeth"),
+		Songs:      fixtureSongs("one", "raining-blood", "holy-wars"),
+		Playlists:  fixturePlaylists("thrash", "headbang"),
+		Genres:     []models.GenreShare{{Genre: "thrash metal", Share: 1}},
+		VibeVector: &models.VibeVector{},
+		InterestRating: map[string]int{
+			"festivals": 5,
+		},
+	}
+}
+
+func TestScoreIdenticalTastes(t *testing.T) {
+	a := indiePopFan()
+	b := indiePopFan()
+
+	result := Score(a, b)
+	if result.Score != 100 {
+		t.Fatalf("Score() = %d, want 100", result.Score)
+	}
+
+	breakdown := result.Breakdown
+	for name, component := range map[string]*float64{
+		"artists":   breakdown.Artists,
+		"songs":     breakdown.Songs,
+		"playlists": breakdown.Playlists,
+		"genres":    breakdown.Genres,
+		"vibe":      breakdown.Vibe,
+		"interests": breakdown.Interests,
+	} {
+		if component == nil || *component != 100 {
+			t.Errorf("%s = %v, want 100", name, component)
+		}
+	}
+}
+
+func TestScoreDisjointTastes(t *testing.T) {
+	result := Score(indiePopFan(), metalFan())
+	if result.Score != 0 {
+		t.Fatalf("Score() = %d, want 0", result.Score)
+	}
+	if len(result.Breakdown.SharedArtists) != 0 || len(result.Breakdown.SharedSongs) != 0 || len(result.Breakdown.SharedGenres) != 0 {
+		t.Errorf("shared = %+v, want nothing shared", result.Breakdown)
+	}
+}
+
+func TestScoreIsSymmetric(t *testing.T) {
+	a := indiePopFan()
+	b := indiePopFan()
+	b.Artists = fixtureArtists("clairo", "metallica", "phoebe")
+	b.Songs = fixtureSongs("coffee", "one")
+	b.Playlists = fixturePlaylists("indie-mix", "thrash")
+	b.Genres = []models.GenreShare{{Genre: "indie pop", Share: 0.3}, {Genre: "thrash metal", Share: 0.7}}
+	b.VibeVector = &models.VibeVector{Energy: 0.3, Valence: 0.8, Tempo: 90}
+	b.InterestRating = map[string]int{"concerts": 2, "festivals": 5}
+
+	ab, ba := Score(a, b), Score(b, a)
+	if ab.Score != ba.Score {
+		t.Fatalf("Score(a, b) = %d, Score(b, a) = %d", ab.Score, ba.Score)
+	}
+	if !reflect.DeepEqual(ab.Breakdown.Artists, ba.Breakdown.Artists) ||
+		!reflect.DeepEqual(ab.Breakdown.Songs, ba.Breakdown.Songs) ||
+		!reflect.DeepEqual(ab.Breakdown.Playlists, ba.Breakdown.Playlists) ||
+		!reflect.DeepEqual(ab.Breakdown.Genres, ba.Breakdown.Genres) ||
+		!reflect.DeepEqual(ab.Breakdown.Vibe, ba.Breakdown.Vibe) ||
+		!reflect.DeepEqual(ab.Breakdown.Interests, ba.Breakdown.Interests) {
+		t.Errorf("breakdowns differ:\n%+v\n%+v", ab.Breakdown, ba.Breakdown)
+	}
+}
+
+func TestScoreLeavesOutMissingComponents(t *testing.T) {
+	a := indiePopFan()
+	b := metalFan()
+	// Only artists are known for b, and they match a's exactly
+	b.Artists = a.Artists
+	b.Songs = nil
+	b.Playlists = nil
+	b.Genres = nil
+	b.VibeVector = nil
+	b.InterestRating = nil
+
+	result := Score(a, b)
+	if result.Score != 100 {
+		t.Errorf("Score() = %d, want 100 from the artists alone", result.Score)
+	}
+
+	breakdown := result.Breakdown
+	if breakdown.Artists == nil {
+		t.Error("artists = nil, want a value")
+	}
+	for name, component := range map[string]*float64{
+		"songs":     breakdown.Songs,
+		"playlists": breakdown.Playlists,
+		"genres":    breakdown.Genres,
+		"vibe":      breakdown.Vibe,
+		"interests": breakdown.Interests,
+	} {
+		if component != nil {
+			t.Errorf("%s = %v, want nil", name, *component)
+		}
+	}
+}
+
+func TestScoreWithoutAnyData(t *testing.T) {
+	result := Score(&Taste{}, indiePopFan())
+	if result.Score != 0 {
+		t.Errorf("Score() = %d, want 0", result.Score)
+	}
+	if result.Breakdown.Artists != nil || result.Breakdown.Vibe != nil {
+		t.Errorf("breakdown = %+v, want no components", result.Breakdown)
+	}
+}
+
+func TestScoreRankWeighting(t *testing.T) {
+	names := make([]string, 50)
+	for i := range names {
+		names[i] = fmt.Sprintf("artist-%02d", i+1)
+	}
+	a := &Taste{Artists: fixtureArtists(names...)}
+	sharesTop := &Taste{Artists: fixtureArtists("artist-01")}
+	sharesBottom := &Taste{Artists: fixtureArtists("artist-50")}
+
+	top, bottom := Score(a, sharesTop), Score(a, sharesBottom)
+	if top.Score <= bottom.Score {
+		t.Errorf("sharing #1 scored %d, sharing #50 scored %d, want #1 higher", top.Score, bottom.Score)
+	}
+}
+
+func TestScoreSharedOrderAndCap(t *testing.T) {
+	a := &Taste{
+		Artists: fixtureArtists("a1", "a2", "a3", "a4", "a5", "a6", "a7"),
+		Songs:   fixtureSongs("s1", "s2", "s3"),
+	}
+	// b ranks the shared artists in reverse, so the combined weight of a4 is
+	// the lowest and a1/a7 tie at the top
+	b := &Taste{
+		Artists: fixtureArtists("a7", "a6", "a5", "a4", "a3", "a2", "a1", "other"),
+		// s3 is b's top song while s1 is only their tenth
+		Songs: fixtureSongs("s3", "x1", "x2", "x3", "x4", "x5", "x6", "x7", "x8", "s1"),
+	}
+
+	breakdown := Score(a, b).Breakdown
+	if len(breakdown.SharedArtists) != maxShared {
+		t.Fatalf("shared artists = %v, want %d", breakdown.SharedArtists, maxShared)
+	}
+	wantArtists := []string{"a1", "a7", "a2", "a6", "a3"}
+	if !reflect.DeepEqual(breakdown.SharedArtists, wantArtists) {
+		t.Errorf("shared artists = %v, want %v", breakdown.SharedArtists, wantArtists)
+	}
+
+	wantSongs := []string{"s3 - Artist", "s1 - Artist"}
+	if !reflect.DeepEqual(breakdown.SharedSongs, wantSongs) {
+		t.Errorf("shared songs = %v, want %v", breakdown.SharedSongs, wantSongs)
+	}
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -28,7 +29,7 @@ func AuthMiddleware(jwtService *auth.JWTService) gin.HandlerFunc {
 		}
 
 		// Validate the token
-		userID, err := jwtService.ValidateToken(parts[1])
+		claims, err := jwtService.ParseToken(parts[1])
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
 			c.Abort()
@@ -36,11 +37,44 @@ func AuthMiddleware(jwtService *auth.JWTService) gin.HandlerFunc {
 		}
 
 		// Set the user ID in the context
-		c.Set("userID", userID)
+		setClaims(c, claims)
 		c.Next()
 	}
 }
 
+// WebSocketAuthMiddleware creates a middleware that validates the same JWT
+// tokens as AuthMiddleware on WebSocket upgrades. Browsers can't set headers
+// on the upgrade request, so the token may also be passed as ?token=.
+func WebSocketAuthMiddleware(jwtService *auth.JWTService) gin.HandlerFunc {
+	headerAuth := AuthMiddleware(jwtService)
+
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" || c.GetHeader("Authorization") != "" {
+			headerAuth(c)
+			return
+		}
+
+		claims, err := jwtService.ParseToken(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+			c.Abort()
+			return
+		}
+
+		setClaims(c, claims)
+		c.Next()
+	}
+}
+
+// setClaims stores the user ID and token expiry of validated claims in the context
+func setClaims(c *gin.Context, claims *auth.CustomClaims) {
+	c.Set("userID", claims.UserID)
+	if claims.ExpiresAt != nil {
+		c.Set("tokenExpiry", claims.ExpiresAt.Time)
+	}
+}
+
 // GetUserID retrieves the user ID from the context
 func GetUserID(c *gin.Context) uuid.UUID {
 	userID, exists := c.Get("userID")
@@ -49,3 +83,13 @@ func GetUserID(c *gin.Context) uuid.UUID {
 	}
 	return userID.(uuid.UUID)
 }
+
+// GetTokenExpiry retrieves the expiry of the request's JWT from the context,
+// or the zero time if it has none
+func GetTokenExpiry(c *gin.Context) time.Time {
+	expiry, exists := c.Get("tokenExpiry")
+	if !exists {
+		return time.Time{}
+	}
+	return expiry.(time.Time)
+}
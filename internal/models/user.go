@@ -1,21 +1,76 @@
 package models
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/geo"
 )
 
-// LastPlayedSong represents a user's last played song from Spotify
+// Values of LastPlayedSong.Type
+const (
+	PlayingTypeTrack   = "track"
+	PlayingTypeEpisode = "episode"
+)
+
+// LastPlayedSong represents a user's last played song from Spotify. For
+// podcast episodes Artist and Album hold the show name and AlbumURI the show URI.
 type LastPlayedSong struct {
-	Track        string `json:"track" db:"track"`
-	Artist       string `json:"artist" db:"artist"`
-	URI          string `json:"uri" db:"uri"`
-	Album        string `json:"album" db:"album"`
-	AlbumURI     string `json:"album_uri" db:"album_uri"`
-	Duration     int    `json:"duration" db:"duration"`
-	ContextTitle string `json:"context_title" db:"context_title"`
-	ContextURI   string `json:"context_uri" db:"context_uri"`
+	Type         string   `json:"type" db:"type"`
+	Track        string   `json:"track" db:"track"`
+	Artist       string   `json:"artist" db:"artist"`
+	ArtistURIs   []string `json:"artist_uris,omitempty" db:"artist_uris"`
+	URI          string   `json:"uri" db:"uri"`
+	Album        string   `json:"album" db:"album"`
+	AlbumURI     string   `json:"album_uri" db:"album_uri"`
+	ImageURL     string   `json:"image_url,omitempty" db:"image_url"`
+	Duration     int      `json:"duration" db:"duration"`
+	ProgressMS   int      `json:"progress_ms" db:"progress_ms"`
+	IsPlaying    bool     `json:"is_playing" db:"is_playing"`
+	ContextTitle string   `json:"context_title" db:"context_title"`
+	ContextURI   string   `json:"context_uri" db:"context_uri"`
+	ContextType  string   `json:"context_type,omitempty" db:"context_type"`
+	// UpdatedAt is when the server recorded the song, as a Unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+const (
+	// LiveGrace is how long past its computed end a song still counts as
+	// playing, covering the gap before the next track is reported
+	LiveGrace = 30 * time.Second
+	// UnknownDuration is assumed for songs whose duration was not reported
+	UnknownDuration = 10 * time.Minute
+)
+
+// StartedAt returns when the song started playing as a Unix timestamp in
+// milliseconds. recordedAt is used for songs saved before UpdatedAt existed.
+func (s *LastPlayedSong) StartedAt(recordedAt int64) int64 {
+	updatedAt := s.UpdatedAt
+	if updatedAt == 0 {
+		updatedAt = recordedAt
+	}
+	return updatedAt - int64(s.ProgressMS)
+}
+
+// IsLive reports whether the song is plausibly still playing at now, given
+// when it started and how long it is
+func (s *LastPlayedSong) IsLive(startedAt int64, now time.Time) bool {
+	if !s.IsPlaying || startedAt <= 0 {
+		return false
+	}
+
+	duration := time.Duration(s.Duration) * time.Millisecond
+	if duration <= 0 {
+		duration = UnknownDuration
+	}
+	return now.Before(time.UnixMilli(startedAt).Add(duration + LiveGrace))
 }
 
 // User represents the main user profile
@@ -25,6 +80,7 @@ type User struct {
 	AccessToken      string          `json:"-" db:"access_token"`
 	RefreshToken     string          `json:"-" db:"refresh_token"`
 	TokenExpiry      time.Time       `json:"-" db:"token_expiry"`
+	NeedsReauth      bool            `json:"-" db:"needs_reauth"`
 	Name             *string         `json:"name" db:"name"`
 	UniversityName   *string         `json:"university_name" db:"university_name"`
 	Work             *WorkProfile    `json:"work" db:"work"`
@@ -38,10 +94,28 @@ type User struct {
 	BirthdayInUnix   *int64          `json:"birthdayInUnix" db:"birthdayInUnix"`
 	Gender           *string         `json:"gender" db:"gender"`
 	DatingPreference *string         `json:"dating_preference" db:"dating_preference"`
+	Location         *Location       `json:"location" db:"-"`
 	CreatedAt        time.Time       `json:"created_at" db:"created_at"`
 	UpdatedAt        time.Time       `json:"updated_at" db:"updated_at"`
 }
 
+// Location is where a user is, rounded so that it never pinpoints them
+type Location struct {
+	Latitude  float64   `json:"latitude" db:"latitude"`
+	Longitude float64   `json:"longitude" db:"longitude"`
+	Geohash   string    `json:"geohash" db:"geohash"`
+	UpdatedAt time.Time `json:"updated_at" db:"location_updated_at"`
+}
+
+// DistanceKM returns the distance between two locations, nil if either is unknown
+func (l *Location) DistanceKM(other *Location) *float64 {
+	if l == nil || other == nil {
+		return nil
+	}
+	km := geo.DistanceKM(l.Latitude, l.Longitude, other.Latitude, other.Longitude)
+	return &km
+}
+
 // WorkProfile represents a user's work information
 type WorkProfile struct {
 	Company *string `json:"company" db:"company"`
@@ -85,6 +159,201 @@ type Artist struct {
 	Name     string    `json:"name" db:"name"`
 	Uri      string    `json:"uri" db:"uri"`
 	ImageURL *string   `json:"image_url" db:"image_url"`
+	Rank     int       `json:"rank" db:"rank"`
+}
+
+// PlaybackStatus reports whether the user's last played song is plausibly
+// still playing and when it started, as a Unix timestamp in milliseconds
+func (u *User) PlaybackStatus(now time.Time) (bool, *int64) {
+	song := u.LastPlayedSong
+	if song == nil {
+		return false, nil
+	}
+
+	var recordedAt int64
+	if u.UserLastActiveAt != nil {
+		recordedAt = *u.UserLastActiveAt * 1000
+	}
+	startedAt := song.StartedAt(recordedAt)
+	if startedAt <= 0 {
+		return false, nil
+	}
+
+	return u.CurrentlyPlaying != nil && song.IsLive(startedAt, now), &startedAt
+}
+
+// NewUserProfile creates a profile from the user's own fields. Currently
+// playing is hidden once the song must have ended, even if the sweeper has not
+// cleared it yet.
+func NewUserProfile(user *User, now time.Time) *UserProfile {
+	profile := &UserProfile{
+		ID:               user.ID,
+		Name:             user.Name,
+		UniversityName:   user.UniversityName,
+		Work:             user.Work,
+		HomeTown:         user.HomeTown,
+		Height:           user.Height,
+		Age:              user.Age,
+		Zodiac:           user.Zodiac,
+		CurrentlyPlaying: user.CurrentlyPlaying,
+		LastPlayedSong:   user.LastPlayedSong,
+		UserLastActiveAt: user.UserLastActiveAt,
+		BirthdayInUnix:   user.BirthdayInUnix,
+		Gender:           user.Gender,
+		DatingPreference: user.DatingPreference,
+		Location:         user.Location,
+	}
+
+	profile.IsLive, profile.StartedAt = user.PlaybackStatus(now)
+	if !profile.IsLive {
+		profile.CurrentlyPlaying = nil
+	}
+	return profile
+}
+
+// Public returns the profile as viewer sees it. It drops the exact birthday,
+// activity timestamp, dating preference and location and reports the age
+// computed from the birthday and the coarse distance from viewer instead.
+// viewer may be nil when their location is unknown.
+func (p *UserProfile) Public(now time.Time, viewer *Location) *UserProfile {
+	public := *p
+	public.BirthdayInUnix = nil
+	public.UserLastActiveAt = nil
+	public.DatingPreference = nil
+	public.Location = nil
+
+	if km := viewer.DistanceKM(p.Location); km != nil {
+		coarse := geo.CoarseKM(*km)
+		public.DistanceKM = &coarse
+	}
+
+	if p.BirthdayInUnix != nil {
+		age := strconv.Itoa(AgeAt(*p.BirthdayInUnix, now))
+		public.Age = &age
+	}
+	return &public
+}
+
+// AgeAt returns the age in whole years of someone born at the given Unix time
+func AgeAt(birthdayInUnix int64, now time.Time) int {
+	birthday := time.Unix(birthdayInUnix, 0).UTC()
+	now = now.UTC()
+
+	age := now.Year() - birthday.Year()
+	if now.Month() < birthday.Month() || (now.Month() == birthday.Month() && now.Day() < birthday.Day()) {
+		age--
+	}
+	return age
+}
+
+// Supported ages in years
+const (
+	MinAge = 18
+	MaxAge = 100
+)
+
+// Plausible heights in centimeters
+const (
+	MinHeightCM = 100
+	MaxHeightCM = 250
+)
+
+var (
+	heightCMPattern   = regexp.MustCompile(`^(\d{2,3}(?:\.\d+)?)\s*(?:cm)?$`)
+	heightMPattern    = regexp.MustCompile(`^([12]\.\d{1,2})\s*m$`)
+	heightFeetPattern = regexp.MustCompile(`^(\d)\s*(?:'|’|ft|feet|foot)\s*(?:(\d{1,2})\s*(?:"|”|''|in|inches)?)?$`)
+)
+
+// ParseHeightCM reads a free text height like "178", "178 cm", "1.78 m" or
+// 5'10" in centimeters. It reports false for text it can't read and for
+// heights outside MinHeightCM and MaxHeightCM.
+func ParseHeightCM(height string) (int, bool) {
+	height = strings.ToLower(strings.TrimSpace(height))
+
+	var cm float64
+	if m := heightCMPattern.FindStringSubmatch(height); m != nil {
+		cm, _ = strconv.ParseFloat(m[1], 64)
+	} else if m := heightMPattern.FindStringSubmatch(height); m != nil {
+		meters, _ := strconv.ParseFloat(m[1], 64)
+		cm = meters * 100
+	} else if m := heightFeetPattern.FindStringSubmatch(height); m != nil {
+		feet, _ := strconv.Atoi(m[1])
+		inches := 0
+		if m[2] != "" {
+			inches, _ = strconv.Atoi(m[2])
+		}
+		if inches >= 12 {
+			return 0, false
+		}
+		cm = float64(feet)*30.48 + float64(inches)*2.54
+	} else {
+		return 0, false
+	}
+
+	rounded := int(math.Round(cm))
+	if rounded < MinHeightCM || rounded > MaxHeightCM {
+		return 0, false
+	}
+	return rounded, true
+}
+
+// HeightCM returns the user's height in centimeters, or nil if it isn't set
+// or can't be read
+func (u *User) HeightCM() *int {
+	if u.Height == nil {
+		return nil
+	}
+	cm, ok := ParseHeightCM(*u.Height)
+	if !ok {
+		return nil
+	}
+	return &cm
+}
+
+// GendersFor returns the genders a dating preference is interested in
+func GendersFor(datingPreference string) []string {
+	switch datingPreference {
+	case "Men":
+		return []string{"Man"}
+	case "Women":
+		return []string{"Woman"}
+	case "Everyone":
+		return []string{"Man", "Woman", "Non-binary"}
+	}
+	return nil
+}
+
+// PreferencesAccepting returns the dating preferences interested in a gender
+func PreferencesAccepting(gender string) []string {
+	switch gender {
+	case "Man":
+		return []string{"Men", "Everyone"}
+	case "Woman":
+		return []string{"Women", "Everyone"}
+	case "Non-binary":
+		return []string{"Everyone"}
+	}
+	return nil
+}
+
+// GenreShare is the share of a user's taste that falls into a genre. Shares
+// across all of a user's genres add up to at most 1.
+type GenreShare struct {
+	Genre string  `json:"genre" db:"genre"`
+	Share float64 `json:"share" db:"share"`
+}
+
+// VibeVector summarizes the audio features of a user's top songs, weighted by
+// rank. Tempo is in beats per minute, the other values range from 0 to 1.
+type VibeVector struct {
+	Energy           float64 `json:"energy"`
+	Valence          float64 `json:"valence"`
+	Danceability     float64 `json:"danceability"`
+	Acousticness     float64 `json:"acousticness"`
+	Instrumentalness float64 `json:"instrumentalness"`
+	Tempo            float64 `json:"tempo"`
+	// SampleSize is how many songs the vector was computed from
+	SampleSize int `json:"sample_size"`
 }
 
 // Song represents a Spotify song
@@ -95,6 +364,7 @@ type Song struct {
 	Artist   string    `json:"artist" db:"artist"`
 	Uri      string    `json:"uri" db:"uri"`
 	ImageURL *string   `json:"image_url" db:"image_url"`
+	Rank     int       `json:"rank" db:"rank"`
 }
 
 // Playlist represents a Spotify playlist
@@ -106,6 +376,23 @@ type Playlist struct {
 	ImageURL *string   `json:"image_url" db:"image_url"`
 }
 
+// ListeningHistoryEntry represents one play from a user's Spotify listening history
+type ListeningHistoryEntry struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	UserID      uuid.UUID `json:"user_id" db:"user_id"`
+	TrackURI    string    `json:"track_uri" db:"track_uri"`
+	TrackName   string    `json:"track_name" db:"track_name"`
+	Artist      string    `json:"artist" db:"artist"`
+	ArtistURIs  []string  `json:"artist_uris" db:"artist_uris"`
+	Album       string    `json:"album" db:"album"`
+	AlbumURI    string    `json:"album_uri" db:"album_uri"`
+	ImageURL    *string   `json:"image_url" db:"image_url"`
+	DurationMS  int       `json:"duration_ms" db:"duration_ms"`
+	ContextURI  *string   `json:"context_uri" db:"context_uri"`
+	ContextType *string   `json:"context_type" db:"context_type"`
+	PlayedAt    time.Time `json:"played_at" db:"played_at"`
+}
+
 // UserProfile represents the complete user profile to be returned by the API
 type UserProfile struct {
 	ID               uuid.UUID       `json:"id"`
@@ -123,10 +410,244 @@ type UserProfile struct {
 	TopArtists       []Artist        `json:"top_artists"`
 	TopSongs         []Song          `json:"top_songs"`
 	SavedPlaylists   []Playlist      `json:"saved_playlists"`
+	Genres           []GenreShare    `json:"genres"`
+	IsLive           bool            `json:"is_live"`
+	StartedAt        *int64          `json:"started_at"`
+	VibeVector       *VibeVector     `json:"vibe_vector"`
 	CurrentlyPlaying *string         `json:"currently_playing"`
 	LastPlayedSong   *LastPlayedSong `json:"last_played_song"`
 	UserLastActiveAt *int64          `json:"user_last_active_at"`
 	BirthdayInUnix   *int64          `json:"birthdayInUnix"`
 	Gender           *string         `json:"gender"`
 	DatingPreference *string         `json:"dating_preference"`
+	Location         *Location       `json:"location"`
+	// DistanceKM is how far the user is from the viewer of a public profile,
+	// coarsened so it can't be used to locate them
+	DistanceKM *int `json:"distance_km"`
+}
+
+// Swipe actions
+const (
+	SwipeLike = "like"
+	SwipePass = "pass"
+)
+
+// Match is a pair of users who liked each other. UserA is always the smaller
+// id so every pair has exactly one row.
+type Match struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	UserA     uuid.UUID `json:"user_a" db:"user_a"`
+	UserB     uuid.UUID `json:"user_b" db:"user_b"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// UnmatchedAt is set once either user unmatched
+	UnmatchedAt *time.Time `json:"unmatched_at,omitempty" db:"unmatched_at"`
+}
+
+// Has reports whether userID is one of the two users in the match
+func (m *Match) Has(userID uuid.UUID) bool {
+	return m.UserA == userID || m.UserB == userID
+}
+
+// OtherUser returns the member of the match who isn't userID
+func (m *Match) OtherUser(userID uuid.UUID) uuid.UUID {
+	if m.UserA == userID {
+		return m.UserB
+	}
+	return m.UserA
+}
+
+// Message types
+const (
+	MessageTypeText = "text"
+)
+
+// MaxMessageTextLength is the longest text message in characters
+const MaxMessageTextLength = 2000
+
+// Message is a chat message between the two users of a match. Seq orders the
+// messages of a match, starting at 1. Payload holds the type specific content,
+// e.g. a TextPayload for text messages.
+type Message struct {
+	ID        uuid.UUID       `json:"id" db:"id"`
+	MatchID   uuid.UUID       `json:"match_id" db:"match_id"`
+	SenderID  uuid.UUID       `json:"sender_id" db:"sender_id"`
+	Seq       int64           `json:"seq" db:"seq"`
+	Type      string          `json:"type" db:"type"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+// TextPayload is the payload of a text message
+type TextPayload struct {
+	Text string `json:"text"`
+}
+
+// MatchSummary is the latest state of a match's conversation for one user
+type MatchSummary struct {
+	LastMessage *Message `json:"last_message"`
+	UnreadCount int64    `json:"unread_count"`
+}
+
+// MaxBlendSongs is the most songs a blended playlist holds
+const MaxBlendSongs = 50
+
+// MatchBlend is a Spotify playlist blended from the top songs of both users
+// of a match. Each user gets their own, created in their Spotify account, and
+// asking again refreshes it instead of creating another one.
+type MatchBlend struct {
+	MatchID     uuid.UUID `json:"match_id" db:"match_id"`
+	UserID      uuid.UUID `json:"user_id" db:"user_id"`
+	PlaylistID  string    `json:"playlist_id" db:"playlist_id"`
+	PlaylistURI string    `json:"playlist_uri" db:"playlist_uri"`
+	TrackCount  int       `json:"track_count" db:"track_count"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Report reasons
+const (
+	ReportReasonSpam                 = "spam"
+	ReportReasonHarassment           = "harassment"
+	ReportReasonInappropriateContent = "inappropriate_content"
+	ReportReasonFakeProfile          = "fake_profile"
+	ReportReasonUnderage             = "underage"
+	ReportReasonOther                = "other"
+)
+
+// ReportReasons lists every valid report reason
+var ReportReasons = []string{
+	ReportReasonSpam,
+	ReportReasonHarassment,
+	ReportReasonInappropriateContent,
+	ReportReasonFakeProfile,
+	ReportReasonUnderage,
+	ReportReasonOther,
+}
+
+// Report statuses. Reports start open until a moderator reviews them.
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusActioned  = "actioned"
+	ReportStatusDismissed = "dismissed"
+)
+
+// MaxReportDetailsLength is the longest report details text in characters
+const MaxReportDetailsLength = 1000
+
+// Report is a user reporting another user for moderation review
+type Report struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	ReporterID uuid.UUID  `json:"reporter_id" db:"reporter_id"`
+	ReportedID uuid.UUID  `json:"reported_id" db:"reported_id"`
+	Reason     string     `json:"reason" db:"reason"`
+	Details    *string    `json:"details" db:"details"`
+	Status     string     `json:"status" db:"status"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty" db:"reviewed_at"`
+}
+
+// Discovery preference fields, as listed in DiscoveryPreferences.Dealbreakers
+const (
+	PreferenceAge           = "age"
+	PreferenceDistance      = "distance"
+	PreferenceHeight        = "height"
+	PreferenceSharedGenres  = "shared_genres"
+	PreferenceSharedArtists = "shared_artists"
+)
+
+// PreferenceFields lists every discovery preference field
+var PreferenceFields = []string{
+	PreferenceAge,
+	PreferenceDistance,
+	PreferenceHeight,
+	PreferenceSharedGenres,
+	PreferenceSharedArtists,
+}
+
+// Limits of discovery preferences
+const (
+	MaxDistanceKM    = 500
+	MaxSharedArtists = 50
+	MaxSharedGenres  = 25
+)
+
+// DiscoveryPreferences are the filters a user applies to discovery. Unset
+// fields don't filter. Fields listed in Dealbreakers are hard filters and
+// candidates who miss them are never shown, the others are soft signals that
+// rank candidates who miss them lower.
+type DiscoveryPreferences struct {
+	MinAge           *int       `json:"min_age" db:"min_age"`
+	MaxAge           *int       `json:"max_age" db:"max_age"`
+	MaxDistanceKM    *int       `json:"max_distance_km" db:"max_distance_km"`
+	MinHeightCM      *int       `json:"min_height_cm" db:"min_height_cm"`
+	MaxHeightCM      *int       `json:"max_height_cm" db:"max_height_cm"`
+	MinSharedGenres  int        `json:"min_shared_genres" db:"min_shared_genres"`
+	MinSharedArtists int        `json:"min_shared_artists" db:"min_shared_artists"`
+	Dealbreakers     []string   `json:"dealbreakers" db:"dealbreakers"`
+	UpdatedAt        *time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// IsSet reports whether the preference field filters anything
+func (p *DiscoveryPreferences) IsSet(field string) bool {
+	switch field {
+	case PreferenceAge:
+		return p.MinAge != nil || p.MaxAge != nil
+	case PreferenceDistance:
+		return p.MaxDistanceKM != nil
+	case PreferenceHeight:
+		return p.MinHeightCM != nil || p.MaxHeightCM != nil
+	case PreferenceSharedGenres:
+		return p.MinSharedGenres > 0
+	case PreferenceSharedArtists:
+		return p.MinSharedArtists > 0
+	}
+	return false
+}
+
+// IsDealbreaker reports whether the preference field is a hard filter
+func (p *DiscoveryPreferences) IsDealbreaker(field string) bool {
+	return p.IsSet(field) && slices.Contains(p.Dealbreakers, field)
+}
+
+// Validate checks that every preference is within its limits and that
+// dealbreakers name preferences that are set
+func (p *DiscoveryPreferences) Validate() error {
+	if err := validateRange("age", p.MinAge, p.MaxAge, MinAge, MaxAge); err != nil {
+		return err
+	}
+	if err := validateRange("height_cm", p.MinHeightCM, p.MaxHeightCM, MinHeightCM, MaxHeightCM); err != nil {
+		return err
+	}
+	if p.MaxDistanceKM != nil && (*p.MaxDistanceKM < 1 || *p.MaxDistanceKM > MaxDistanceKM) {
+		return fmt.Errorf("max_distance_km must be between 1 and %d", MaxDistanceKM)
+	}
+	if p.MinSharedGenres < 0 || p.MinSharedGenres > MaxSharedGenres {
+		return fmt.Errorf("min_shared_genres must be between 0 and %d", MaxSharedGenres)
+	}
+	if p.MinSharedArtists < 0 || p.MinSharedArtists > MaxSharedArtists {
+		return fmt.Errorf("min_shared_artists must be between 0 and %d", MaxSharedArtists)
+	}
+
+	for _, field := range p.Dealbreakers {
+		if !slices.Contains(PreferenceFields, field) {
+			return fmt.Errorf("dealbreakers must be some of %s", strings.Join(PreferenceFields, ", "))
+		}
+		if !p.IsSet(field) {
+			return fmt.Errorf("dealbreaker %s has no preference set", field)
+		}
+	}
+	return nil
+}
+
+// validateRange checks an optional min and max against the limits and each other
+func validateRange(name string, low, high *int, min, max int) error {
+	for _, value := range []*int{low, high} {
+		if value != nil && (*value < min || *value > max) {
+			return fmt.Errorf("min_%s and max_%s must be between %d and %d", name, name, min, max)
+		}
+	}
+	if low != nil && high != nil && *low > *high {
+		return fmt.Errorf("min_%s must not be greater than max_%s", name, name)
+	}
+	return nil
 }
@@ -0,0 +1,107 @@
+package musicsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/spotify"
+)
+
+const (
+	// maxGenres is how many genres are kept in a user's distribution
+	maxGenres = 25
+	// trackArtistWeight scales how much the artists of top songs count
+	// compared to top artists
+	trackArtistWeight = 0.5
+)
+
+// fetchArtistGenres fetches the full artist objects for the user's top artists
+// and the artists of their top tracks, stores them in the artist catalog and
+// returns their genres by artist id
+func (s *Service) fetchArtistGenres(ctx context.Context, accessToken string, topArtists []spotify.Artist, topTracks []spotify.Track) (map[string][]string, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	addID := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, artist := range topArtists {
+		addID(artist.ID)
+	}
+	for _, track := range topTracks {
+		for _, artist := range track.Artists {
+			addID(artist.ID)
+		}
+	}
+
+	artists, err := s.SpotifyClient.GetArtists(ctx, accessToken, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := make([]db.CatalogArtist, len(artists))
+	genres := make(map[string][]string, len(artists))
+	for i, artist := range artists {
+		catalog[i] = db.CatalogArtist{
+			URI:        artist.URI,
+			Name:       artist.Name,
+			Popularity: artist.Popularity,
+			Genres:     artist.Genres,
+		}
+		genres[artist.ID] = artist.Genres
+	}
+
+	if err := s.DB.SaveCatalogArtists(ctx, catalog); err != nil {
+		return nil, fmt.Errorf("error saving artists: %w", err)
+	}
+
+	return genres, nil
+}
+
+// genreDistribution weighs every genre by the rank of the top artists and top
+// tracks it appears on and returns the largest shares. A track's weight is
+// split between its artists.
+func genreDistribution(topArtists []spotify.Artist, topTracks []spotify.Track, artistGenres map[string][]string) []models.GenreShare {
+	weights := make(map[string]float64)
+	total := 0.0
+	add := func(artistID string, weight float64) {
+		for _, genre := range artistGenres[artistID] {
+			weights[genre] += weight
+			total += weight
+		}
+	}
+
+	for i, artist := range topArtists {
+		add(artist.ID, float64(len(topArtists)-i))
+	}
+	for i, track := range topTracks {
+		if len(track.Artists) == 0 {
+			continue
+		}
+		weight := trackArtistWeight * float64(len(topTracks)-i) / float64(len(track.Artists))
+		for _, artist := range track.Artists {
+			add(artist.ID, weight)
+		}
+	}
+
+	genres := make([]models.GenreShare, 0, len(weights))
+	for genre, weight := range weights {
+		genres = append(genres, models.GenreShare{Genre: genre, Share: weight / total})
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		if genres[i].Share != genres[j].Share {
+			return genres[i].Share > genres[j].Share
+		}
+		return genres[i].Genre < genres[j].Genre
+	})
+
+	if len(genres) > maxGenres {
+		genres = genres[:maxGenres]
+	}
+	return genres
+}
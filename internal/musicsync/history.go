@@ -0,0 +1,145 @@
+package musicsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/spotify"
+	"github.com/matchmyvibe/backend/internal/tokens"
+)
+
+// HistoryIngester periodically copies each user's recently played tracks from
+// Spotify into their listening history
+type HistoryIngester struct {
+	Sync *Service
+
+	// Interval is how often the ingester looks for users that are due
+	Interval time.Duration
+	// MinInterval is how long a user's history stays fresh between ingestions
+	MinInterval time.Duration
+	// BatchSize bounds how many users are ingested per tick
+	BatchSize int
+	// RetryBackoff is how long a user is skipped after a failed ingestion,
+	// doubled on every further failure up to MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+// NewHistoryIngester creates a new HistoryIngester with default settings
+func NewHistoryIngester(service *Service) *HistoryIngester {
+	return &HistoryIngester{
+		Sync:            service,
+		Interval:        5 * time.Minute,
+		MinInterval:     30 * time.Minute,
+		BatchSize:       50,
+		RetryBackoff:    30 * time.Minute,
+		MaxRetryBackoff: 24 * time.Hour,
+	}
+}
+
+// Start ingests listening history in the background until ctx is done
+func (h *HistoryIngester) Start(ctx context.Context) {
+	ticker := time.NewTicker(h.Interval)
+	defer ticker.Stop()
+
+	for {
+		h.ingestDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ingestDue ingests the history of every user whose history went stale
+func (h *HistoryIngester) ingestDue(ctx context.Context) {
+	users, err := h.Sync.DB.GetUsersDueForHistoryIngest(ctx, time.Now().Add(-h.MinInterval), h.BatchSize)
+	if err != nil {
+		fmt.Printf("[ERROR] HistoryIngester - Error loading users: %v\n", err)
+		return
+	}
+
+	for i := range users {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if _, err := h.Sync.IngestHistory(ctx, &users[i]); err != nil {
+			fmt.Printf("[ERROR] HistoryIngester - Error ingesting history for user %s: %v\n", users[i].ID, err)
+
+			// Back off from the whole batch while Spotify is throttling us
+			if errors.Is(err, spotify.ErrRateLimited) {
+				return
+			}
+
+			// Keep a user whose ingestion keeps failing, e.g. because they never
+			// granted user-read-recently-played, from taking up every batch
+			if err := h.Sync.DB.RecordHistoryIngestFailure(ctx, users[i].ID, h.RetryBackoff, h.MaxRetryBackoff); err != nil {
+				fmt.Printf("[ERROR] HistoryIngester - Error recording failure for user %s: %v\n", users[i].ID, err)
+			}
+		}
+	}
+}
+
+// IngestHistory stores the plays made since the user's last ingestion and
+// returns how many new plays were saved
+func (s *Service) IngestHistory(ctx context.Context, user *models.User) (int, error) {
+	afterMS, err := s.DB.GetHistoryCursor(ctx, user.ID)
+	if err != nil {
+		return 0, fmt.Errorf("error loading history cursor: %w", err)
+	}
+
+	accessToken, err := s.Tokens.AccessToken(ctx, user)
+	if err != nil {
+		if errors.Is(err, tokens.ErrNeedsReauth) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	plays, cursor, err := s.SpotifyClient.GetRecentlyPlayed(ctx, accessToken, afterMS)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching recently played: %w", err)
+	}
+
+	entries := make([]models.ListeningHistoryEntry, 0, len(plays))
+	for _, play := range plays {
+		if play.Track.URI == "" {
+			continue
+		}
+
+		entry := models.ListeningHistoryEntry{
+			UserID:     user.ID,
+			TrackURI:   play.Track.URI,
+			TrackName:  play.Track.Name,
+			Artist:     play.Track.ArtistNames(),
+			ArtistURIs: make([]string, 0, len(play.Track.Artists)),
+			Album:      play.Track.Album.Name,
+			AlbumURI:   play.Track.Album.URI,
+			ImageURL:   imageURL(play.Track.Album.Images),
+			DurationMS: play.Track.DurationMS,
+			PlayedAt:   play.PlayedAt.UTC(),
+		}
+		for _, artist := range play.Track.Artists {
+			entry.ArtistURIs = append(entry.ArtistURIs, artist.URI)
+		}
+		if play.Context != nil {
+			entry.ContextURI = &play.Context.URI
+			entry.ContextType = &play.Context.Type
+		}
+		entries = append(entries, entry)
+	}
+
+	inserted, err := s.DB.SaveListeningHistory(ctx, user.ID, entries, cursor)
+	if err != nil {
+		return 0, fmt.Errorf("error saving listening history: %w", err)
+	}
+
+	fmt.Printf("[DEBUG] IngestHistory - Saved %d of %d plays for user %s\n", inserted, len(plays), user.ID)
+	return inserted, nil
+}
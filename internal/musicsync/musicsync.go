@@ -0,0 +1,172 @@
+// Package musicsync imports a user's music library from Spotify into the database
+package musicsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/spotify"
+	"github.com/matchmyvibe/backend/internal/tokens"
+)
+
+// coverImageSize is the minimum width in pixels preferred for stored cover art
+const coverImageSize = 300
+
+// Service syncs Spotify data for users
+type Service struct {
+	DB            *db.DB
+	SpotifyClient *spotify.Client
+	Tokens        *tokens.Manager
+
+	// TimeRange is the Spotify time range top items are synced for
+	TimeRange string
+	// Limit is the maximum number of top artists and top songs stored per user
+	Limit int
+	// MinInterval is how long a sync stays fresh before SyncIfStale runs again
+	MinInterval time.Duration
+}
+
+// New creates a new sync Service with default settings
+func New(database *db.DB, spotifyClient *spotify.Client, tokenManager *tokens.Manager) *Service {
+	return &Service{
+		DB:            database,
+		SpotifyClient: spotifyClient,
+		Tokens:        tokenManager,
+		TimeRange:     spotify.TimeRangeMedium,
+		Limit:         50,
+		MinInterval:   6 * time.Hour,
+	}
+}
+
+// SyncIfStale syncs the user's Spotify data unless it was synced within
+// MinInterval. It reports whether a sync ran and when the data was last synced.
+func (s *Service) SyncIfStale(ctx context.Context, user *models.User) (bool, time.Time, error) {
+	syncedAt, err := s.DB.GetTopItemsSyncedAt(ctx, user.ID)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("error checking last sync: %w", err)
+	}
+
+	if syncedAt != nil && time.Since(*syncedAt) < s.MinInterval {
+		return false, *syncedAt, nil
+	}
+
+	now := time.Now()
+	if err := s.Sync(ctx, user); err != nil {
+		return false, time.Time{}, err
+	}
+
+	return true, now, nil
+}
+
+// Sync imports the user's Spotify data regardless of when it was last synced
+func (s *Service) Sync(ctx context.Context, user *models.User) error {
+	accessToken, err := s.Tokens.AccessToken(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	if err := s.SyncTopItems(ctx, user, accessToken); err != nil {
+		return err
+	}
+
+	return s.SyncPlaylists(ctx, user, accessToken)
+}
+
+// SyncTopItems replaces the user's top artists and songs with the current
+// ones from Spotify and recomputes their genre distribution and vibe vector
+func (s *Service) SyncTopItems(ctx context.Context, user *models.User, accessToken string) error {
+	topArtists, err := s.SpotifyClient.GetTopArtists(ctx, accessToken, s.TimeRange, s.Limit)
+	if err != nil {
+		return fmt.Errorf("error fetching top artists: %w", err)
+	}
+
+	topTracks, err := s.SpotifyClient.GetTopTracks(ctx, accessToken, s.TimeRange, s.Limit)
+	if err != nil {
+		return fmt.Errorf("error fetching top tracks: %w", err)
+	}
+
+	artistGenres, err := s.fetchArtistGenres(ctx, accessToken, topArtists, topTracks)
+	if err != nil {
+		return fmt.Errorf("error fetching artist genres: %w", err)
+	}
+
+	vector, err := s.fetchVibeVector(ctx, accessToken, topTracks)
+	if err != nil {
+		return fmt.Errorf("error fetching audio features: %w", err)
+	}
+
+	artists := make([]models.Artist, len(topArtists))
+	for i, artist := range topArtists {
+		artists[i] = models.Artist{
+			UserID:   user.ID,
+			Name:     artist.Name,
+			Uri:      artist.URI,
+			ImageURL: imageURL(artist.Images),
+			Rank:     i + 1,
+		}
+	}
+
+	songs := make([]models.Song, len(topTracks))
+	for i, track := range topTracks {
+		songs[i] = models.Song{
+			UserID:   user.ID,
+			Name:     track.Name,
+			Artist:   track.ArtistNames(),
+			Uri:      track.URI,
+			ImageURL: imageURL(track.Album.Images),
+			Rank:     i + 1,
+		}
+	}
+
+	items := db.TopItems{
+		Artists:    artists,
+		Songs:      songs,
+		Genres:     genreDistribution(topArtists, topTracks, artistGenres),
+		VibeVector: vector,
+	}
+	if err := s.DB.ReplaceUserTopItems(ctx, user.ID, items, time.Now()); err != nil {
+		return fmt.Errorf("error saving top items: %w", err)
+	}
+
+	fmt.Printf("[DEBUG] SyncTopItems - Synced %d artists, %d songs and %d genres for user %s\n", len(artists), len(songs), len(items.Genres), user.ID)
+	return nil
+}
+
+// SyncPlaylists reconciles the user's saved playlists with the ones currently
+// in their Spotify library
+func (s *Service) SyncPlaylists(ctx context.Context, user *models.User, accessToken string) error {
+	spotifyPlaylists, err := s.SpotifyClient.GetUserPlaylists(ctx, accessToken)
+	if err != nil {
+		return fmt.Errorf("error fetching playlists: %w", err)
+	}
+
+	playlists := make([]models.Playlist, len(spotifyPlaylists))
+	for i, playlist := range spotifyPlaylists {
+		playlists[i] = models.Playlist{
+			UserID:   user.ID,
+			Name:     playlist.Name,
+			Uri:      playlist.URI,
+			ImageURL: imageURL(playlist.Images),
+		}
+	}
+
+	added, removed, updated, err := s.DB.ReconcileUserPlaylists(ctx, user.ID, playlists)
+	if err != nil {
+		return fmt.Errorf("error saving playlists: %w", err)
+	}
+
+	fmt.Printf("[DEBUG] SyncPlaylists - User %s: %d added, %d removed, %d updated\n", user.ID, added, removed, updated)
+	return nil
+}
+
+// imageURL returns the best cover image URL, or nil if there are no images
+func imageURL(images []spotify.Image) *string {
+	url := spotify.BestImageURL(images, coverImageSize)
+	if url == "" {
+		return nil
+	}
+	return &url
+}
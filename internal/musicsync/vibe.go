@@ -0,0 +1,78 @@
+package musicsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/spotify"
+)
+
+// fetchVibeVector fetches the audio features of the user's top tracks and
+// averages them weighted by rank. It returns nil if Spotify has no features
+// for any of the tracks or does not let this app read them.
+func (s *Service) fetchVibeVector(ctx context.Context, accessToken string, topTracks []spotify.Track) (*models.VibeVector, error) {
+	ids := make([]string, 0, len(topTracks))
+	for _, track := range topTracks {
+		if track.ID != "" {
+			ids = append(ids, track.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	features, err := s.SpotifyClient.GetAudioFeatures(ctx, accessToken, ids)
+	if err != nil {
+		// Spotify only grants the audio features endpoint to some apps, keep the
+		// rest of the sync working without it
+		if errors.Is(err, spotify.ErrForbidden) || errors.Is(err, spotify.ErrScopeMissing) {
+			fmt.Printf("[DEBUG] fetchVibeVector - Audio features unavailable: %v\n", err)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return vibeVector(topTracks, features), nil
+}
+
+// vibeVector averages the audio features of the top tracks, giving higher
+// ranked tracks more weight
+func vibeVector(topTracks []spotify.Track, features []spotify.AudioFeatures) *models.VibeVector {
+	byID := make(map[string]spotify.AudioFeatures, len(features))
+	for _, f := range features {
+		byID[f.ID] = f
+	}
+
+	var vector models.VibeVector
+	total := 0.0
+	for i, track := range topTracks {
+		f, ok := byID[track.ID]
+		if !ok {
+			continue
+		}
+
+		weight := float64(len(topTracks) - i)
+		vector.Energy += weight * f.Energy
+		vector.Valence += weight * f.Valence
+		vector.Danceability += weight * f.Danceability
+		vector.Acousticness += weight * f.Acousticness
+		vector.Instrumentalness += weight * f.Instrumentalness
+		vector.Tempo += weight * f.Tempo
+		vector.SampleSize++
+		total += weight
+	}
+
+	if vector.SampleSize == 0 {
+		return nil
+	}
+
+	vector.Energy /= total
+	vector.Valence /= total
+	vector.Danceability /= total
+	vector.Acousticness /= total
+	vector.Instrumentalness /= total
+	vector.Tempo /= total
+	return &vector
+}
@@ -0,0 +1,60 @@
+package musicsync
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/matchmyvibe/backend/internal/spotify"
+	"github.com/matchmyvibe/backend/internal/spotify/spotifytest"
+)
+
+// newVibeService returns a Service talking to a fake Spotify server and an
+// access token for its user
+func newVibeService(t *testing.T) (*Service, *spotifytest.Server, string) {
+	t.Helper()
+
+	server := spotifytest.NewServer()
+	t.Cleanup(server.Close)
+	user := server.AddUser(spotifytest.User{ID: "alice"})
+
+	return &Service{SpotifyClient: server.Client()}, server, user.AccessToken
+}
+
+func TestFetchVibeVector(t *testing.T) {
+	s, server, accessToken := newVibeService(t)
+	server.AddAudioFeatures(
+		spotifytest.AudioFeatures{ID: "t1", Energy: 1, Tempo: 120},
+		spotifytest.AudioFeatures{ID: "t2", Energy: 0, Tempo: 90},
+	)
+
+	// t1 outranks t2 and t3 has no audio features
+	topTracks := []spotify.Track{{ID: "t1"}, {ID: "t2"}, {ID: "t3"}}
+	vector, err := s.fetchVibeVector(context.Background(), accessToken, topTracks)
+	if err != nil {
+		t.Fatalf("fetchVibeVector() error = %v", err)
+	}
+	if vector == nil || vector.SampleSize != 2 {
+		t.Fatalf("fetchVibeVector() = %+v, want a vector of 2 tracks", vector)
+	}
+	if math.Abs(vector.Energy-0.6) > 1e-9 || math.Abs(vector.Tempo-108) > 1e-9 {
+		t.Errorf("vector = %+v, want energy 0.6 and tempo 108 weighted by rank", vector)
+	}
+}
+
+func TestFetchVibeVectorUnavailable(t *testing.T) {
+	for _, message := range []string{"Insufficient client scope", "Forbidden"} {
+		s, server, accessToken := newVibeService(t)
+		server.Fail("/v1/audio-features", spotifytest.Failure{
+			Status: http.StatusForbidden,
+			Body:   `{"error": {"status": 403, "message": "` + message + `"}}`,
+		})
+
+		// Apps without audio features access still sync, just without a vector
+		vector, err := s.fetchVibeVector(context.Background(), accessToken, []spotify.Track{{ID: "t1"}})
+		if err != nil || vector != nil {
+			t.Errorf("403 %q: fetchVibeVector() = %+v, %v, want no vector and no error", message, vector, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package playback
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/realtime"
+)
+
+// CurrentlyPlayingEvent is the data of a match.currently_playing event
+type CurrentlyPlayingEvent struct {
+	MatchID          uuid.UUID              `json:"match_id"`
+	UserID           uuid.UUID              `json:"user_id"`
+	CurrentlyPlaying *string                `json:"currently_playing"`
+	LastPlayedSong   *models.LastPlayedSong `json:"last_played_song"`
+	IsLive           bool                   `json:"is_live"`
+	StartedAt        *int64                 `json:"started_at"`
+}
+
+// Notifier tells a user's matches when what they are listening to changes
+// and when they are listening to the same thing
+type Notifier struct {
+	DB  *db.DB
+	Hub *realtime.Hub
+
+	// VibingWindow is how long after a match's song was last seen playing it
+	// still counts as listening to the same thing
+	VibingWindow time.Duration
+	// VibingCooldown is the minimum time between two vibing events of a match
+	VibingCooldown time.Duration
+}
+
+// NewNotifier creates a new Notifier with default settings
+func NewNotifier(database *db.DB, hub *realtime.Hub) *Notifier {
+	return &Notifier{
+		DB:             database,
+		Hub:            hub,
+		VibingWindow:   10 * time.Minute,
+		VibingCooldown: time.Hour,
+	}
+}
+
+// SongChanged pushes the user's saved playback state to each of their active
+// matches and tells the ones listening to the same thing. Calling it on a nil
+// Notifier does nothing.
+func (n *Notifier) SongChanged(ctx context.Context, user *models.User) {
+	if n == nil || n.Hub == nil {
+		return
+	}
+
+	matches, err := n.DB.GetActiveMatches(ctx, user.ID)
+	if err != nil {
+		fmt.Printf("[ERROR] Notifier - Error loading matches of user %s: %v\n", user.ID, err)
+		return
+	}
+
+	event := CurrentlyPlayingEvent{
+		UserID:           user.ID,
+		CurrentlyPlaying: user.CurrentlyPlaying,
+		LastPlayedSong:   user.LastPlayedSong,
+	}
+	event.IsLive, event.StartedAt = user.PlaybackStatus(time.Now())
+	if !event.IsLive {
+		event.CurrentlyPlaying = nil
+	}
+
+	for _, match := range matches {
+		event.MatchID = match.ID
+		n.Hub.Publish(ctx, []uuid.UUID{match.OtherUser(user.ID)}, realtime.EventCurrentlyPlaying, event)
+	}
+
+	n.detectVibing(ctx, user, matches)
+}
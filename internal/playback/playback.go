@@ -0,0 +1,97 @@
+// Package playback keeps track of what users are listening to
+package playback
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/spotify"
+)
+
+// coverImageSize is the minimum width in pixels preferred for album art
+const coverImageSize = 300
+
+// SongFromSpotify converts the Spotify playback state into the LastPlayedSong
+// stored on the user. It returns nil when no track or episode is playing.
+func SongFromSpotify(cp *spotify.CurrentlyPlaying) *models.LastPlayedSong {
+	if cp == nil || cp.Item == nil {
+		return nil
+	}
+
+	item := cp.Item
+	song := &models.LastPlayedSong{
+		Track:      item.Name,
+		URI:        item.URI,
+		Duration:   item.DurationMS,
+		ProgressMS: cp.ProgressMS,
+		IsPlaying:  cp.IsPlaying,
+	}
+
+	switch {
+	case item.Type == spotify.PlayingTypeEpisode && item.Show != nil:
+		song.Type = models.PlayingTypeEpisode
+		song.Artist = item.Show.Name
+		song.Album = item.Show.Name
+		song.AlbumURI = item.Show.URI
+		song.ImageURL = spotify.BestImageURL(item.Images, coverImageSize)
+		if song.ImageURL == "" {
+			song.ImageURL = spotify.BestImageURL(item.Show.Images, coverImageSize)
+		}
+	default:
+		song.Type = models.PlayingTypeTrack
+		song.Artist = item.ArtistNames()
+		for _, artist := range item.Artists {
+			song.ArtistURIs = append(song.ArtistURIs, artist.URI)
+		}
+		if item.Album != nil {
+			song.Album = item.Album.Name
+			song.AlbumURI = item.Album.URI
+			song.ImageURL = spotify.BestImageURL(item.Album.Images, coverImageSize)
+		}
+	}
+
+	if cp.Context != nil {
+		song.ContextURI = cp.Context.URI
+		song.ContextType = cp.Context.Type
+
+		// The player API doesn't name the context, but album and show contexts
+		// are the item's own album or show
+		if cp.Context.URI == song.AlbumURI {
+			song.ContextTitle = song.Album
+		}
+	}
+
+	return song
+}
+
+// Apply stores the song as the user's last played song and, while it is
+// playing, as their currently playing song. It returns the currently playing
+// display string. A nil song clears currently playing but keeps the last
+// played song.
+func Apply(user *models.User, song *models.LastPlayedSong) *string {
+	if song == nil {
+		user.CurrentlyPlaying = nil
+		return nil
+	}
+
+	if song.Type == "" {
+		song.Type = models.PlayingTypeTrack
+	}
+	song.UpdatedAt = time.Now().UnixMilli()
+	user.LastPlayedSong = song
+
+	if !song.IsPlaying {
+		user.CurrentlyPlaying = nil
+		return nil
+	}
+
+	currentlyPlaying := Format(song)
+	user.CurrentlyPlaying = &currentlyPlaying
+	return &currentlyPlaying
+}
+
+// Format returns the "Track - Artist" display string for a song
+func Format(song *models.LastPlayedSong) string {
+	return fmt.Sprintf("%s - %s", song.Track, song.Artist)
+}
@@ -0,0 +1,269 @@
+package playback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/spotify"
+	"github.com/matchmyvibe/backend/internal/tokens"
+)
+
+// restartDrift is how far the computed start of the same track may move
+// before it counts as a restart or seek
+const restartDrift = 15 * time.Second
+
+// defaultRateLimitPause is how long polling stops after a 429 without a Retry-After
+const defaultRateLimitPause = 30 * time.Second
+
+// Poller keeps the currently playing track of recently active users up to
+// date by polling the Spotify player API
+type Poller struct {
+	DB            *db.DB
+	SpotifyClient *spotify.Client
+	Tokens        *tokens.Manager
+	// Notifier is told about every change the poller saves, it may be nil
+	Notifier *Notifier
+
+	// ActiveWithin is how recently a user must have been active to be polled
+	ActiveWithin time.Duration
+	// Interval is how often users that are listening are polled
+	Interval time.Duration
+	// IdleBackoff is the first delay after a poll found nothing playing. It
+	// doubles on every idle poll up to MaxIdleBackoff.
+	IdleBackoff    time.Duration
+	MaxIdleBackoff time.Duration
+	// Workers is how many users are polled concurrently
+	Workers int
+
+	mu          sync.Mutex
+	schedule    map[uuid.UUID]pollState
+	pausedUntil time.Time
+}
+
+// pollState is when a user is due for their next poll
+type pollState struct {
+	next    time.Time
+	backoff time.Duration
+}
+
+// NewPoller creates a new Poller with default settings
+func NewPoller(database *db.DB, spotifyClient *spotify.Client, tokenManager *tokens.Manager) *Poller {
+	return &Poller{
+		DB:             database,
+		SpotifyClient:  spotifyClient,
+		Tokens:         tokenManager,
+		ActiveWithin:   15 * time.Minute,
+		Interval:       30 * time.Second,
+		IdleBackoff:    time.Minute,
+		MaxIdleBackoff: 10 * time.Minute,
+		Workers:        4,
+		schedule:       make(map[uuid.UUID]pollState),
+	}
+}
+
+// Start polls active users in the background until ctx is done
+func (p *Poller) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.pollDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollDue polls every active user whose next poll is due and waits for the
+// workers to finish
+func (p *Poller) pollDue(ctx context.Context) {
+	now := time.Now()
+	if now.Before(p.paused()) {
+		return
+	}
+
+	users, err := p.DB.GetUsersActiveSince(ctx, now.Add(-p.ActiveWithin))
+	if err != nil {
+		fmt.Printf("[ERROR] Poller - Error loading active users: %v\n", err)
+		return
+	}
+
+	due := p.dueUsers(users, now)
+	if len(due) == 0 {
+		return
+	}
+
+	jobs := make(chan *models.User)
+	var wg sync.WaitGroup
+	for i := 0; i < p.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				p.poll(ctx, user)
+			}
+		}()
+	}
+
+	for _, user := range due {
+		// Stop handing out work once Spotify starts throttling us
+		if ctx.Err() != nil || time.Now().Before(p.paused()) {
+			break
+		}
+		jobs <- user
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// dueUsers returns the users whose next poll is due and forgets users that
+// are no longer active
+func (p *Poller) dueUsers(users []models.User, now time.Time) []*models.User {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	active := make(map[uuid.UUID]bool, len(users))
+	var due []*models.User
+	for i := range users {
+		active[users[i].ID] = true
+		if state, ok := p.schedule[users[i].ID]; !ok || !now.Before(state.next) {
+			due = append(due, &users[i])
+		}
+	}
+
+	for userID := range p.schedule {
+		if !active[userID] {
+			delete(p.schedule, userID)
+		}
+	}
+
+	return due
+}
+
+// poll fetches the user's playback state and saves it if the track changed
+func (p *Poller) poll(ctx context.Context, user *models.User) {
+	accessToken, err := p.Tokens.AccessToken(ctx, user)
+	if err != nil {
+		if !errors.Is(err, tokens.ErrNeedsReauth) {
+			fmt.Printf("[ERROR] Poller - Error getting access token for user %s: %v\n", user.ID, err)
+		}
+		p.backOff(user.ID)
+		return
+	}
+
+	cp, err := p.SpotifyClient.GetCurrentlyPlaying(ctx, accessToken)
+	if err != nil {
+		if errors.Is(err, spotify.ErrRateLimited) {
+			pause := spotify.RetryAfter(err)
+			if pause <= 0 {
+				pause = defaultRateLimitPause
+			}
+			p.pause(time.Now().Add(pause))
+			return
+		}
+		fmt.Printf("[ERROR] Poller - Error fetching playback for user %s: %v\n", user.ID, err)
+		p.backOff(user.ID)
+		return
+	}
+
+	song := SongFromSpotify(cp)
+	if song == nil || !song.IsPlaying {
+		p.backOff(user.ID)
+	} else {
+		p.reset(user.ID)
+	}
+
+	if !Changed(user, song) {
+		return
+	}
+
+	// Reload the user so profile edits made since the poll started are kept
+	fresh, err := p.DB.GetUserByID(ctx, user.ID)
+	if err != nil || fresh == nil {
+		fmt.Printf("[ERROR] Poller - Error reloading user %s: %v\n", user.ID, err)
+		return
+	}
+
+	Apply(fresh, song)
+	if err := p.DB.UpdateUser(ctx, fresh); err != nil {
+		fmt.Printf("[ERROR] Poller - Error saving playback for user %s: %v\n", user.ID, err)
+		return
+	}
+
+	p.Notifier.SongChanged(ctx, fresh)
+}
+
+// Changed reports whether saving song would change what the user is shown to
+// be listening to or when it started
+func Changed(user *models.User, song *models.LastPlayedSong) bool {
+	if song == nil {
+		return user.CurrentlyPlaying != nil
+	}
+
+	last := user.LastPlayedSong
+	if last == nil || last.URI != song.URI {
+		return true
+	}
+	if (user.CurrentlyPlaying != nil) != song.IsPlaying {
+		return true
+	}
+	if !song.IsPlaying {
+		return false
+	}
+
+	// Replaying or seeking within the same track moves its start
+	_, startedAt := user.PlaybackStatus(time.Now())
+	if startedAt == nil {
+		return true
+	}
+	drift := time.Duration(time.Now().UnixMilli()-int64(song.ProgressMS)-*startedAt) * time.Millisecond
+	return drift > restartDrift || drift < -restartDrift
+}
+
+// backOff delays the user's next poll, doubling the delay every time
+func (p *Poller) backOff(userID uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	backoff := p.schedule[userID].backoff * 2
+	if backoff < p.IdleBackoff {
+		backoff = p.IdleBackoff
+	}
+	if backoff > p.MaxIdleBackoff {
+		backoff = p.MaxIdleBackoff
+	}
+	p.schedule[userID] = pollState{next: time.Now().Add(backoff), backoff: backoff}
+}
+
+// reset polls the user again on the next tick
+func (p *Poller) reset(userID uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.schedule, userID)
+}
+
+func (p *Poller) pause(until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if until.After(p.pausedUntil) {
+		p.pausedUntil = until
+	}
+}
+
+func (p *Poller) paused() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.pausedUntil
+}
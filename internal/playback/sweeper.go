@@ -0,0 +1,47 @@
+package playback
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/matchmyvibe/backend/internal/db"
+)
+
+// Sweeper clears the currently playing track of users whose last played song
+// has definitely ended
+type Sweeper struct {
+	DB *db.DB
+
+	// Interval is how often the sweeper runs
+	Interval time.Duration
+}
+
+// NewSweeper creates a new Sweeper with default settings
+func NewSweeper(database *db.DB) *Sweeper {
+	return &Sweeper{
+		DB:       database,
+		Interval: time.Minute,
+	}
+}
+
+// Start sweeps in the background until ctx is done
+func (s *Sweeper) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		cleared, err := s.DB.ClearEndedCurrentlyPlaying(ctx, time.Now())
+		if err != nil {
+			fmt.Printf("[ERROR] Sweeper - Error clearing ended songs: %v\n", err)
+		} else if cleared > 0 {
+			fmt.Printf("[DEBUG] Sweeper - Cleared currently playing for %d users\n", cleared)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
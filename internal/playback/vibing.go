@@ -0,0 +1,123 @@
+package playback
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/realtime"
+)
+
+// What two users are both listening to, strongest first
+const (
+	VibingTrack  = "track"
+	VibingAlbum  = "album"
+	VibingArtist = "artist"
+)
+
+// VibingEvent is the data of a match.vibing event. UserID and LastPlayedSong
+// are the other user's.
+type VibingEvent struct {
+	MatchID        uuid.UUID              `json:"match_id"`
+	UserID         uuid.UUID              `json:"user_id"`
+	Level          string                 `json:"level"`
+	URI            string                 `json:"uri"`
+	LastPlayedSong *models.LastPlayedSong `json:"last_played_song"`
+}
+
+// detectVibing tells the user and each of their matches who recently played
+// the same track, album or artist as the user's current song, at most once
+// per VibingCooldown for every match
+func (n *Notifier) detectVibing(ctx context.Context, user *models.User, matches []models.Match) {
+	song := user.LastPlayedSong
+	if song == nil || !song.IsPlaying || len(matches) == 0 {
+		return
+	}
+
+	byOther := make(map[uuid.UUID]models.Match, len(matches))
+	otherIDs := make([]uuid.UUID, 0, len(matches))
+	for _, match := range matches {
+		otherID := match.OtherUser(user.ID)
+		byOther[otherID] = match
+		otherIDs = append(otherIDs, otherID)
+	}
+
+	others, err := n.DB.GetUsersByIDs(ctx, otherIDs)
+	if err != nil {
+		fmt.Printf("[ERROR] Notifier - Error loading matches of user %s: %v\n", user.ID, err)
+		return
+	}
+
+	now := time.Now()
+	for i := range others {
+		other := &others[i]
+		if !listenedWithin(other, n.VibingWindow, now) {
+			continue
+		}
+
+		level, uri := overlap(song, other.LastPlayedSong)
+		if level == "" {
+			continue
+		}
+
+		match := byOther[other.ID]
+		claimed, err := n.DB.ClaimVibingNotification(ctx, match.ID, level, uri, n.VibingCooldown)
+		if err != nil {
+			fmt.Printf("[ERROR] Notifier - Error recording vibing for match %s: %v\n", match.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		fmt.Printf("[DEBUG] Notifier - Users %s and %s are both listening to %s %s\n", user.ID, other.ID, level, uri)
+		n.Hub.Publish(ctx, []uuid.UUID{user.ID}, realtime.EventVibing, VibingEvent{
+			MatchID:        match.ID,
+			UserID:         other.ID,
+			Level:          level,
+			URI:            uri,
+			LastPlayedSong: other.LastPlayedSong,
+		})
+		n.Hub.Publish(ctx, []uuid.UUID{other.ID}, realtime.EventVibing, VibingEvent{
+			MatchID:        match.ID,
+			UserID:         user.ID,
+			Level:          level,
+			URI:            uri,
+			LastPlayedSong: song,
+		})
+	}
+}
+
+// listenedWithin reports whether the user's last played song is still
+// playing or was seen playing within window
+func listenedWithin(user *models.User, window time.Duration, now time.Time) bool {
+	song := user.LastPlayedSong
+	if song == nil || !song.IsPlaying {
+		return false
+	}
+
+	if isLive, _ := user.PlaybackStatus(now); isLive {
+		return true
+	}
+	return song.UpdatedAt >= now.Add(-window).UnixMilli()
+}
+
+// overlap returns the strongest thing two songs have in common and its URI,
+// or an empty level when they have nothing in common
+func overlap(a, b *models.LastPlayedSong) (string, string) {
+	if a.URI != "" && a.URI == b.URI {
+		return VibingTrack, a.URI
+	}
+	if a.AlbumURI != "" && a.AlbumURI == b.AlbumURI {
+		return VibingAlbum, a.AlbumURI
+	}
+	for _, uri := range a.ArtistURIs {
+		if uri != "" && slices.Contains(b.ArtistURIs, uri) {
+			return VibingArtist, uri
+		}
+	}
+	return "", ""
+}
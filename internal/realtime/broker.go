@@ -0,0 +1,56 @@
+package realtime
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// DeliverFunc hands an event published for a user to the local connections
+type DeliverFunc func(userID uuid.UUID, event Event)
+
+// Broker carries events between API instances. Every instance's Hub
+// subscribes to the broker and delivers what it receives to its own
+// connections, so an event reaches a user whichever instance they are
+// connected to. A broker backed by Redis or Postgres LISTEN/NOTIFY can replace
+// LocalBroker once the API runs on more than one instance.
+type Broker interface {
+	// Publish sends the event to every subscriber for each of the users
+	Publish(ctx context.Context, userIDs []uuid.UUID, event Event) error
+	// Subscribe registers deliver to receive every published event
+	Subscribe(deliver DeliverFunc) error
+}
+
+// LocalBroker is a Broker that only delivers within the current process
+type LocalBroker struct {
+	mu          sync.RWMutex
+	subscribers []DeliverFunc
+}
+
+// NewLocalBroker creates a new LocalBroker
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{}
+}
+
+// Publish delivers the event to every subscriber for each of the users
+func (b *LocalBroker) Publish(ctx context.Context, userIDs []uuid.UUID, event Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, userID := range userIDs {
+		for _, deliver := range b.subscribers {
+			deliver(userID, event)
+		}
+	}
+	return nil
+}
+
+// Subscribe registers deliver to receive every published event
+func (b *LocalBroker) Subscribe(deliver DeliverFunc) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers = append(b.subscribers, deliver)
+	return nil
+}
@@ -0,0 +1,292 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+)
+
+// EventError is sent to a client right before the server closes its connection
+const EventError = "error"
+
+// handleTimeout limits how long handling one client message may take
+const handleTimeout = 5 * time.Second
+
+// InboundHandler handles a message sent by one of the user's clients other
+// than the heartbeat
+type InboundHandler func(ctx context.Context, userID uuid.UUID, msg Inbound)
+
+// Hub keeps track of the WebSocket connections on this instance and fans
+// events out to every connection of a user
+type Hub struct {
+	Broker Broker
+
+	// SendBuffer is how many events may be queued for one connection. A client
+	// that falls further behind is disconnected so it can't hold up the hub,
+	// it catches up through the REST endpoints when it reconnects.
+	SendBuffer int
+	// MaxConnectionsPerUser limits how many connections one user may hold open
+	MaxConnectionsPerUser int
+	// PingInterval is how often the server pings clients
+	PingInterval time.Duration
+	// PongWait is how long a connection may stay silent before it is closed.
+	// Any message from the client counts, not only pong.
+	PongWait time.Duration
+	// WriteTimeout limits how long writing one message may take
+	WriteTimeout time.Duration
+	// MaxMessageBytes limits the size of messages clients may send
+	MaxMessageBytes int
+
+	mu      sync.RWMutex
+	clients map[uuid.UUID]map[*client]struct{}
+}
+
+// client is a single WebSocket connection
+type client struct {
+	userID uuid.UUID
+	conn   *websocket.Conn
+	send   chan []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewHub creates a new Hub with default settings that delivers the events
+// published on broker
+func NewHub(broker Broker) (*Hub, error) {
+	hub := &Hub{
+		Broker:                broker,
+		SendBuffer:            64,
+		MaxConnectionsPerUser: 5,
+		PingInterval:          30 * time.Second,
+		PongWait:              75 * time.Second,
+		WriteTimeout:          10 * time.Second,
+		MaxMessageBytes:       4096,
+		clients:               make(map[uuid.UUID]map[*client]struct{}),
+	}
+
+	if err := broker.Subscribe(hub.deliver); err != nil {
+		return nil, err
+	}
+	return hub, nil
+}
+
+// Publish sends an event to every connection of each of the users, on any
+// instance. Delivery is best effort and errors are only logged. Publishing on
+// a nil Hub does nothing, so real-time events stay optional for callers.
+func (h *Hub) Publish(ctx context.Context, userIDs []uuid.UUID, eventType string, data interface{}) {
+	if h == nil || len(userIDs) == 0 {
+		return
+	}
+
+	event, err := NewEvent(eventType, data)
+	if err != nil {
+		fmt.Printf("[ERROR] Hub - Error encoding %s event: %v\n", eventType, err)
+		return
+	}
+
+	if err := h.Broker.Publish(ctx, userIDs, event); err != nil {
+		fmt.Printf("[ERROR] Hub - Error publishing %s event: %v\n", eventType, err)
+	}
+}
+
+// deliver queues the event on every local connection of the user and
+// disconnects the ones whose queue is full
+func (h *Hub) deliver(userID uuid.UUID, event Event) {
+	h.mu.RLock()
+	clients := make([]*client, 0, len(h.clients[userID]))
+	for c := range h.clients[userID] {
+		clients = append(clients, c)
+	}
+	h.mu.RUnlock()
+
+	if len(clients) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("[ERROR] Hub - Error encoding %s event: %v\n", event.Type, err)
+		return
+	}
+
+	for _, c := range clients {
+		if !c.enqueue(data) {
+			fmt.Printf("[DEBUG] Hub - Disconnecting slow client of user %s\n", userID)
+			c.close()
+		}
+	}
+}
+
+// Serve runs a connection of the user until either side closes it or the
+// token it was authenticated with expires at expiresAt. A zero expiresAt
+// never expires. It is meant to be called as the websocket.Handler of an
+// authenticated request.
+func (h *Hub) Serve(conn *websocket.Conn, userID uuid.UUID, expiresAt time.Time, handle InboundHandler) {
+	conn.MaxPayloadBytes = h.MaxMessageBytes
+
+	c := &client{
+		userID: userID,
+		conn:   conn,
+		send:   make(chan []byte, h.SendBuffer),
+		done:   make(chan struct{}),
+	}
+	defer c.close()
+
+	if !h.register(c) {
+		h.writeError(c, "too many connections")
+		return
+	}
+	defer h.unregister(c)
+
+	// The client reconnects with a fresh token once this one expires
+	var expired <-chan time.Time
+	if !expiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(expiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	go h.writeLoop(c, expired)
+	h.readLoop(c, handle)
+}
+
+func (h *Hub) register(c *client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	clients := h.clients[c.userID]
+	if len(clients) >= h.MaxConnectionsPerUser {
+		return false
+	}
+	if clients == nil {
+		clients = make(map[*client]struct{})
+		h.clients[c.userID] = clients
+	}
+	clients[c] = struct{}{}
+	return true
+}
+
+func (h *Hub) unregister(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients[c.userID], c)
+	if len(h.clients[c.userID]) == 0 {
+		delete(h.clients, c.userID)
+	}
+}
+
+// readLoop reads client messages until the connection fails or stays silent
+// for longer than PongWait
+func (h *Hub) readLoop(c *client, handle InboundHandler) {
+	pong, err := json.Marshal(Event{Type: MessagePong})
+	if err != nil {
+		return
+	}
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(h.PongWait))
+
+		var data []byte
+		if err := websocket.Message.Receive(c.conn, &data); err != nil {
+			return
+		}
+
+		var msg Inbound
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case MessagePong:
+		case MessagePing:
+			if !c.enqueue(pong) {
+				return
+			}
+		default:
+			if handle != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), handleTimeout)
+				handle(ctx, c.userID, msg)
+				cancel()
+			}
+		}
+	}
+}
+
+// writeLoop writes queued events and pings the client until the connection
+// is closed or expired fires
+func (h *Hub) writeLoop(c *client, expired <-chan time.Time) {
+	ping, err := json.Marshal(Event{Type: MessagePing})
+	if err != nil {
+		c.close()
+		return
+	}
+
+	ticker := time.NewTicker(h.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		var data []byte
+		select {
+		case <-c.done:
+			return
+		case data = <-c.send:
+		case <-ticker.C:
+			data = ping
+		case <-expired:
+			h.writeError(c, "token expired")
+			c.close()
+			return
+		}
+
+		if err := h.write(c, data); err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+// writeError tells the client why the server is about to close its connection
+func (h *Hub) writeError(c *client, message string) {
+	event, err := NewEvent(EventError, map[string]string{"error": message})
+	if err != nil {
+		return
+	}
+	if data, err := json.Marshal(event); err == nil {
+		h.write(c, data)
+	}
+}
+
+func (h *Hub) write(c *client, data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(h.WriteTimeout))
+	return websocket.Message.Send(c.conn, string(data))
+}
+
+// enqueue queues data for the writer. It reports false when the queue is full.
+func (c *client) enqueue(data []byte) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+	}
+
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,65 @@
+// Package realtime pushes events to users' connected clients over WebSockets
+package realtime
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// Event types pushed to clients
+const (
+	// EventMatchCreated is sent to both users when a like creates a match
+	EventMatchCreated = "match.new"
+	// EventMessageCreated is sent to both users of a match when a message is sent
+	EventMessageCreated = "message.new"
+	// EventTyping is sent to the other user of a match while the user is typing
+	EventTyping = "typing"
+	// EventCurrentlyPlaying is sent to a user's matches when what they are
+	// listening to changes
+	EventCurrentlyPlaying = "match.currently_playing"
+	// EventVibing is sent to both users of a match when they are listening to
+	// the same track, album or artist
+	EventVibing = "match.vibing"
+)
+
+// Heartbeat messages. The server sends ping every PingInterval and clients
+// answer with pong, clients may also ping the server themselves.
+const (
+	MessagePing = "ping"
+	MessagePong = "pong"
+)
+
+// Event is a message pushed to a user's clients
+type Event struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// NewEvent creates an event with data encoded as JSON
+func NewEvent(eventType string, data interface{}) (Event, error) {
+	event := Event{Type: eventType}
+	if data == nil {
+		return event, nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	event.Data = encoded
+	return event, nil
+}
+
+// Inbound is a message sent by a client
+type Inbound struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// TypingData is the data of a typing event, both sent by clients and pushed
+// to the other user of the match
+type TypingData struct {
+	MatchID uuid.UUID `json:"match_id"`
+	UserID  uuid.UUID `json:"user_id,omitempty"`
+}
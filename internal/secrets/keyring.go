@@ -0,0 +1,146 @@
+// Package secrets encrypts sensitive values before they are stored
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedPrefix marks values produced by Encrypt. Values without it are
+// legacy plaintext.
+const encryptedPrefix = "enc:"
+
+// ErrUnknownKey means a value was encrypted with a key the keyring doesn't have
+var ErrUnknownKey = errors.New("secrets: unknown key id")
+
+// Keyring encrypts values with AES-GCM under its current key and decrypts
+// values encrypted under any of its keys. Encrypted values look like
+// "enc:<key id>:<base64 nonce and ciphertext>" so old keys can be rotated out.
+type Keyring struct {
+	keys      map[string]cipher.AEAD
+	currentID string
+}
+
+// NewKeyring creates a keyring from AES keys (16, 24 or 32 bytes) by key id.
+// New values are encrypted with the key named currentID.
+func NewKeyring(keys map[string][]byte, currentID string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD, len(keys)), currentID: currentID}
+
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("secrets: invalid key id %q", id)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("secrets: key %s: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("secrets: key %s: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+
+	if _, ok := k.keys[currentID]; !ok {
+		return nil, fmt.Errorf("secrets: current key %q is not in the keyring", currentID)
+	}
+	return k, nil
+}
+
+// LoadKeyring builds a keyring from a ParseKeys key list and the id of the
+// key to encrypt with. It returns nil if no keys are configured.
+func LoadKeyring(keySpec, currentID string) (*Keyring, error) {
+	keys, err := ParseKeys(keySpec)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return NewKeyring(keys, currentID)
+}
+
+// ParseKeys parses a comma separated list of "<key id>:<base64 key>" pairs
+func ParseKeys(spec string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("secrets: key %q is not in <id>:<base64 key> form", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("secrets: key %s is not valid base64: %w", id, err)
+		}
+		if _, exists := keys[id]; exists {
+			return nil, fmt.Errorf("secrets: duplicate key id %s", id)
+		}
+		keys[id] = key
+	}
+
+	return keys, nil
+}
+
+// Encrypt encrypts plaintext under the current key. aad binds the value to
+// where it is stored, decrypting it requires the same aad.
+func (k *Keyring) Encrypt(plaintext, aad string) (string, error) {
+	aead := k.keys[k.currentID]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(aad))
+	return encryptedPrefix + k.currentID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt with the same aad. Legacy
+// plaintext values are returned unchanged.
+func (k *Keyring) Decrypt(value, aad string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok {
+		return "", errors.New("secrets: malformed encrypted value")
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("secrets: malformed encrypted value")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("secrets: decrypting with key %s: %w", id, err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// CurrentPrefix returns the prefix of values encrypted under the current key
+func (k *Keyring) CurrentPrefix() string {
+	return encryptedPrefix + k.currentID + ":"
+}
@@ -1,21 +1,89 @@
 package spotify
 
 import (
-	"encoding/base64"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	// DefaultAccountsURL is the base URL of the Spotify accounts service
+	DefaultAccountsURL = "https://accounts.spotify.com"
+	// DefaultAPIURL is the base URL of the Spotify Web API
+	DefaultAPIURL = "https://api.spotify.com"
+)
+
 // Client represents a Spotify API client
 type Client struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURI  string
 	HTTPClient   *http.Client
+
+	// AccountsURL and APIURL are the base URLs requests are sent to, without a trailing slash
+	AccountsURL string
+	APIURL      string
+
+	// Limiter is shared by every request the client sends
+	Limiter *Limiter
+	// MaxRetries is how many times rate limited and 5xx requests are retried
+	MaxRetries int
+	// BaseBackoff is the first retry delay, doubled on every further attempt
+	BaseBackoff time.Duration
+	// MaxBackoff caps retry delays. A Retry-After longer than this is returned
+	// to the caller as ErrRateLimited instead of waited out.
+	MaxBackoff time.Duration
+}
+
+// Option configures a Client created with New
+type Option func(*Client)
+
+// WithAccountsURL overrides the base URL of the Spotify accounts service
+func WithAccountsURL(baseURL string) Option {
+	return func(c *Client) {
+		c.AccountsURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithAPIURL overrides the base URL of the Spotify Web API
+func WithAPIURL(baseURL string) Option {
+	return func(c *Client) {
+		c.APIURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithRateLimit replaces the client's limiter with one allowing rate requests
+// per second and bursts of burst requests
+func WithRateLimit(rate float64, burst int) Option {
+	return func(c *Client) {
+		c.Limiter = NewLimiter(rate, burst)
+	}
+}
+
+// WithRetries overrides how many times and how long failed requests are retried
+func WithRetries(maxRetries int, baseBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.MaxRetries = maxRetries
+		c.BaseBackoff = baseBackoff
+		c.MaxBackoff = maxBackoff
+	}
+}
+
+// WithHTTPClient overrides the HTTP client used for requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.HTTPClient = httpClient
+	}
 }
 
 // TokenResponse represents the response from the Spotify token API
@@ -27,99 +95,713 @@ type TokenResponse struct {
 	Scope        string `json:"scope"`
 }
 
+// CurrentUser represents the profile returned by the Spotify /v1/me endpoint
+type CurrentUser struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	URI         string `json:"uri"`
+	Email       string `json:"email,omitempty"`
+	Country     string `json:"country,omitempty"`
+	Product     string `json:"product,omitempty"`
+}
+
+// Time ranges accepted by the top items endpoints
+const (
+	TimeRangeShort  = "short_term"
+	TimeRangeMedium = "medium_term"
+	TimeRangeLong   = "long_term"
+)
+
+// maxPageSize is the largest limit the paginated Spotify endpoints accept
+const maxPageSize = 50
+
+// Image represents an image returned by the Spotify API. Width and height are
+// nil when Spotify does not know the dimensions.
+type Image struct {
+	URL    string `json:"url"`
+	Width  *int   `json:"width"`
+	Height *int   `json:"height"`
+}
+
+// Artist represents a Spotify artist
+type Artist struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	URI        string   `json:"uri"`
+	Genres     []string `json:"genres"`
+	Popularity int      `json:"popularity"`
+	Images     []Image  `json:"images"`
+}
+
+// Album represents a Spotify album
+type Album struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	URI    string  `json:"uri"`
+	Images []Image `json:"images"`
+}
+
+// Track represents a Spotify track
+type Track struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	URI        string   `json:"uri"`
+	DurationMS int      `json:"duration_ms"`
+	Artists    []Artist `json:"artists"`
+	Album      Album    `json:"album"`
+}
+
+// Playlist represents a simplified Spotify playlist
+type Playlist struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	URI    string  `json:"uri"`
+	Public bool    `json:"public"`
+	Images []Image `json:"images"`
+}
+
+// BestImageURL picks the smallest image that is at least minSize pixels wide,
+// falling back to the largest image with known dimensions and then to the first
+// image. It returns an empty string when there are no images.
+func BestImageURL(images []Image, minSize int) string {
+	var best, largest *Image
+	for i := range images {
+		image := &images[i]
+		if image.URL == "" || image.Width == nil {
+			continue
+		}
+		if *image.Width >= minSize && (best == nil || *image.Width < *best.Width) {
+			best = image
+		}
+		if largest == nil || *image.Width > *largest.Width {
+			largest = image
+		}
+	}
+
+	switch {
+	case best != nil:
+		return best.URL
+	case largest != nil:
+		return largest.URL
+	}
+
+	// Spotify sends user uploaded playlist covers without dimensions
+	for _, image := range images {
+		if image.URL != "" {
+			return image.URL
+		}
+	}
+	return ""
+}
+
+// ArtistNames joins the names of the track's artists
+func (t *Track) ArtistNames() string {
+	return joinArtistNames(t.Artists)
+}
+
+// ArtistNames joins the names of the item's artists
+func (i *PlaybackItem) ArtistNames() string {
+	return joinArtistNames(i.Artists)
+}
+
+func joinArtistNames(artists []Artist) string {
+	names := make([]string, len(artists))
+	for i, artist := range artists {
+		names[i] = artist.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Values of CurrentlyPlaying.Type
+const (
+	PlayingTypeTrack   = "track"
+	PlayingTypeEpisode = "episode"
+	PlayingTypeAd      = "ad"
+	PlayingTypeUnknown = "unknown"
+)
+
+// Show represents the podcast an episode belongs to
+type Show struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	URI       string  `json:"uri"`
+	Publisher string  `json:"publisher"`
+	Images    []Image `json:"images"`
+}
+
+// PlaybackItem is the track or episode being played. Artists and Album are set
+// for tracks, Show and Images for episodes.
+type PlaybackItem struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	URI        string   `json:"uri"`
+	Type       string   `json:"type"`
+	DurationMS int      `json:"duration_ms"`
+	Artists    []Artist `json:"artists"`
+	Album      *Album   `json:"album"`
+	Show       *Show    `json:"show"`
+	Images     []Image  `json:"images"`
+}
+
+// PlaybackContext is the album, playlist, artist or show playback started from
+type PlaybackContext struct {
+	Type string `json:"type"`
+	URI  string `json:"uri"`
+}
+
+// Device is the device playback happens on
+type Device struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	IsActive      bool   `json:"is_active"`
+	VolumePercent *int   `json:"volume_percent"`
+}
+
+// CurrentlyPlaying is the user's playback state
+type CurrentlyPlaying struct {
+	IsPlaying  bool             `json:"is_playing"`
+	ProgressMS int              `json:"progress_ms"`
+	Timestamp  int64            `json:"timestamp"`
+	Type       string           `json:"currently_playing_type"`
+	Item       *PlaybackItem    `json:"item"`
+	Context    *PlaybackContext `json:"context"`
+	// Device is only returned by the full playback state endpoint, which
+	// needs the user-read-playback-state scope
+	Device *Device `json:"device"`
+}
+
+// AudioFeatures are Spotify's audio analysis values for a track. Tempo is in
+// beats per minute, the other values range from 0 to 1.
+type AudioFeatures struct {
+	ID               string  `json:"id"`
+	URI              string  `json:"uri"`
+	Energy           float64 `json:"energy"`
+	Valence          float64 `json:"valence"`
+	Danceability     float64 `json:"danceability"`
+	Acousticness     float64 `json:"acousticness"`
+	Instrumentalness float64 `json:"instrumentalness"`
+	Tempo            float64 `json:"tempo"`
+}
+
+// PlayHistory is one play from the user's recently played tracks
+type PlayHistory struct {
+	Track    Track            `json:"track"`
+	PlayedAt time.Time        `json:"played_at"`
+	Context  *PlaybackContext `json:"context"`
+}
+
+// maxArtistsPerRequest is how many ids the several artists endpoint accepts at once
+const maxArtistsPerRequest = 50
+
+// maxAudioFeaturesPerRequest is how many ids the several audio features endpoint accepts at once
+const maxAudioFeaturesPerRequest = 100
+
+// maxPlaylistItemsPerRequest is how many items the playlist items endpoints accept at once
+const maxPlaylistItemsPerRequest = 100
+
+// maxRecentlyPlayedPages bounds how many pages one GetRecentlyPlayed call walks
+const maxRecentlyPlayedPages = 10
+
+// page represents a Spotify offset/limit paging object
+type page[T any] struct {
+	Items []T     `json:"items"`
+	Next  *string `json:"next"`
+	Total int     `json:"total"`
+}
+
 // New creates a new Spotify client
-func New(clientID, clientSecret, redirectURI string) *Client {
-	return &Client{
+func New(clientID, clientSecret, redirectURI string, opts ...Option) *Client {
+	c := &Client{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		RedirectURI:  redirectURI,
 		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		AccountsURL:  DefaultAccountsURL,
+		APIURL:       DefaultAPIURL,
+		Limiter:      NewLimiter(10, 20),
+		MaxRetries:   3,
+		BaseBackoff:  500 * time.Millisecond,
+		MaxBackoff:   30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// ExchangeCode exchanges an authorization code obtained with the PKCE flow for
+// an access token and refresh token
+func (c *Client) ExchangeCode(ctx context.Context, code, codeVerifier string) (*TokenResponse, error) {
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", c.RedirectURI)
+	data.Set("client_id", c.ClientID)
+	data.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.AccountsURL+"/api/token", strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCE exchanges authenticate with the code verifier instead of the client secret
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var tokenResponse TokenResponse
+	if _, err := c.do(req, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("spotify code exchange failed: %w", err)
+	}
+
+	if tokenResponse.AccessToken == "" {
+		return nil, fmt.Errorf("spotify code exchange returned no access token")
+	}
+
+	return &tokenResponse, nil
+}
+
+// GetCurrentUser gets the profile of the user that owns the access token
+func (c *Client) GetCurrentUser(ctx context.Context, accessToken string) (*CurrentUser, error) {
+	var user CurrentUser
+	if err := c.getJSON(ctx, accessToken, c.APIURL+"/v1/me", &user); err != nil {
+		return nil, err
+	}
+
+	if user.ID == "" {
+		return nil, fmt.Errorf("spotify profile response did not include a user ID")
 	}
+
+	// The URI is derived from the ID if Spotify ever omits it
+	if user.URI == "" {
+		user.URI = "spotify:user:" + user.ID
+	}
+
+	return &user, nil
 }
 
 // RefreshToken refreshes an access token using a refresh token
-func (c *Client) RefreshToken(refreshToken string) (*TokenResponse, error) {
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 	data.Set("refresh_token", refreshToken)
+	data.Set("client_id", c.ClientID)
 
-	req, err := http.NewRequest("POST", "https://accounts.spotify.com/api/token", strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.AccountsURL+"/api/token", strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, err
 	}
 
-	// Set required headers
+	// Refresh tokens issued to a PKCE client are refreshed with the client ID
+	// alone, like the code exchange
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.ClientID+":"+c.ClientSecret)))
 
-	// Send the request
-	resp, err := c.HTTPClient.Do(req)
+	var tokenResponse TokenResponse
+	if _, err := c.do(req, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("spotify token refresh failed: %w", err)
+	}
+
+	return &tokenResponse, nil
+}
+
+// GetCurrentlyPlaying gets the track or podcast episode the user is playing.
+// It only needs the user-read-currently-playing scope, so the playback device
+// is not included. It returns nil when nothing is playing.
+func (c *Client) GetCurrentlyPlaying(ctx context.Context, accessToken string) (*CurrentlyPlaying, error) {
+	query := url.Values{}
+	query.Set("additional_types", PlayingTypeTrack+","+PlayingTypeEpisode)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.APIURL+"/v1/me/player/currently-playing?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var playback CurrentlyPlaying
+	status, err := c.do(req, &playback)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// Check for error response
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("spotify token refresh failed with status: %d", resp.StatusCode)
+	// No content means there is no active device
+	if status == http.StatusNoContent || playback.Item == nil {
+		return nil, nil
 	}
 
-	// Parse the response
-	var tokenResponse TokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+	return &playback, nil
+}
+
+// GetTopArtists gets up to limit of the user's top artists for a time range,
+// following pagination as needed
+func (c *Client) GetTopArtists(ctx context.Context, accessToken, timeRange string, limit int) ([]Artist, error) {
+	query := url.Values{}
+	query.Set("time_range", timeRange)
+	return getPages[Artist](ctx, c, accessToken, c.APIURL+"/v1/me/top/artists", query, limit)
+}
+
+// GetTopTracks gets up to limit of the user's top tracks for a time range,
+// following pagination as needed
+func (c *Client) GetTopTracks(ctx context.Context, accessToken, timeRange string, limit int) ([]Track, error) {
+	query := url.Values{}
+	query.Set("time_range", timeRange)
+	return getPages[Track](ctx, c, accessToken, c.APIURL+"/v1/me/top/tracks", query, limit)
+}
+
+// GetUserPlaylists gets every playlist the user owns or follows
+func (c *Client) GetUserPlaylists(ctx context.Context, accessToken string) ([]Playlist, error) {
+	playlists, err := getPages[Playlist](ctx, c, accessToken, c.APIURL+"/v1/me/playlists", url.Values{}, 0)
+	if err != nil {
 		return nil, err
 	}
 
-	return &tokenResponse, nil
+	// Spotify occasionally returns null entries for playlists that were removed
+	valid := playlists[:0]
+	for _, playlist := range playlists {
+		if playlist.URI != "" {
+			valid = append(valid, playlist)
+		}
+	}
+	return valid, nil
+}
+
+// GetArtists gets the full artist objects, including genres and popularity,
+// for the given artist ids. Ids Spotify does not know are skipped.
+func (c *Client) GetArtists(ctx context.Context, accessToken string, ids []string) ([]Artist, error) {
+	artists := make([]Artist, 0, len(ids))
+
+	for start := 0; start < len(ids); start += maxArtistsPerRequest {
+		end := start + maxArtistsPerRequest
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		query := url.Values{}
+		query.Set("ids", strings.Join(ids[start:end], ","))
+
+		var response struct {
+			Artists []*Artist `json:"artists"`
+		}
+		if err := c.getJSON(ctx, accessToken, c.APIURL+"/v1/artists?"+query.Encode(), &response); err != nil {
+			return nil, err
+		}
+
+		for _, artist := range response.Artists {
+			if artist != nil {
+				artists = append(artists, *artist)
+			}
+		}
+	}
+
+	return artists, nil
+}
+
+// GetAudioFeatures gets the audio features for the given track ids. Tracks
+// Spotify has no features for are skipped.
+func (c *Client) GetAudioFeatures(ctx context.Context, accessToken string, ids []string) ([]AudioFeatures, error) {
+	features := make([]AudioFeatures, 0, len(ids))
+
+	for start := 0; start < len(ids); start += maxAudioFeaturesPerRequest {
+		end := start + maxAudioFeaturesPerRequest
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		query := url.Values{}
+		query.Set("ids", strings.Join(ids[start:end], ","))
+
+		var response struct {
+			AudioFeatures []*AudioFeatures `json:"audio_features"`
+		}
+		if err := c.getJSON(ctx, accessToken, c.APIURL+"/v1/audio-features?"+query.Encode(), &response); err != nil {
+			return nil, err
+		}
+
+		for _, f := range response.AudioFeatures {
+			if f != nil {
+				features = append(features, *f)
+			}
+		}
+	}
+
+	return features, nil
+}
+
+// GetRecentlyPlayed gets the tracks the user played after the given cursor
+// (a Unix timestamp in milliseconds, zero for everything Spotify still has).
+// It returns the plays oldest first and the cursor to pass next time.
+func (c *Client) GetRecentlyPlayed(ctx context.Context, accessToken string, after int64) ([]PlayHistory, int64, error) {
+	var plays []PlayHistory
+	cursor := after
+
+	for i := 0; i < maxRecentlyPlayedPages; i++ {
+		query := url.Values{}
+		query.Set("limit", fmt.Sprint(maxPageSize))
+		query.Set("after", fmt.Sprint(cursor))
+
+		var response struct {
+			Items   []PlayHistory `json:"items"`
+			Next    *string       `json:"next"`
+			Cursors *struct {
+				After string `json:"after"`
+			} `json:"cursors"`
+		}
+		if err := c.getJSON(ctx, accessToken, c.APIURL+"/v1/me/player/recently-played?"+query.Encode(), &response); err != nil {
+			return nil, after, err
+		}
+
+		next := cursor
+		for _, play := range response.Items {
+			if ms := play.PlayedAt.UnixMilli(); ms > next {
+				next = ms
+			}
+		}
+		if response.Cursors != nil {
+			if ms, err := strconv.ParseInt(response.Cursors.After, 10, 64); err == nil && ms > next {
+				next = ms
+			}
+		}
+
+		plays = append(plays, response.Items...)
+
+		// A full page with a next link means there may be even newer plays
+		if len(response.Items) < maxPageSize || response.Next == nil || next == cursor {
+			cursor = next
+			break
+		}
+		cursor = next
+	}
+
+	sort.Slice(plays, func(i, j int) bool {
+		return plays[i].PlayedAt.Before(plays[j].PlayedAt)
+	})
+
+	return plays, cursor, nil
+}
+
+// CreatePlaylist creates an empty playlist in the account of the Spotify user
+// with the given id, who must be the owner of the access token. It requires
+// the playlist-modify-private scope, or playlist-modify-public for public
+// playlists.
+func (c *Client) CreatePlaylist(ctx context.Context, accessToken, userID, name, description string, public bool) (*Playlist, error) {
+	body := map[string]interface{}{
+		"name":        name,
+		"description": description,
+		"public":      public,
+	}
+
+	var playlist Playlist
+	if err := c.sendJSON(ctx, accessToken, "POST", c.APIURL+"/v1/users/"+url.PathEscape(userID)+"/playlists", body, &playlist); err != nil {
+		return nil, err
+	}
+
+	if playlist.ID == "" {
+		return nil, fmt.Errorf("spotify create playlist response did not include a playlist ID")
+	}
+
+	return &playlist, nil
+}
+
+// AddPlaylistItems appends track or episode URIs to the end of a playlist,
+// in as many requests as needed
+func (c *Client) AddPlaylistItems(ctx context.Context, accessToken, playlistID string, uris []string) error {
+	for start := 0; start < len(uris); start += maxPlaylistItemsPerRequest {
+		end := start + maxPlaylistItemsPerRequest
+		if end > len(uris) {
+			end = len(uris)
+		}
+
+		body := map[string]interface{}{"uris": uris[start:end]}
+		if err := c.sendJSON(ctx, accessToken, "POST", c.APIURL+"/v1/playlists/"+url.PathEscape(playlistID)+"/tracks", body, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReplacePlaylistItems replaces every item of a playlist with the given track
+// or episode URIs. An empty list clears the playlist.
+func (c *Client) ReplacePlaylistItems(ctx context.Context, accessToken, playlistID string, uris []string) error {
+	// Only the first batch can replace, the rest is appended after it
+	first := uris
+	if len(first) > maxPlaylistItemsPerRequest {
+		first = first[:maxPlaylistItemsPerRequest]
+	}
+	if first == nil {
+		first = []string{}
+	}
+
+	body := map[string]interface{}{"uris": first}
+	if err := c.sendJSON(ctx, accessToken, "PUT", c.APIURL+"/v1/playlists/"+url.PathEscape(playlistID)+"/tracks", body, nil); err != nil {
+		return err
+	}
+
+	return c.AddPlaylistItems(ctx, accessToken, playlistID, uris[len(first):])
 }
 
-// GetCurrentlyPlaying gets the user's currently playing track
-func (c *Client) GetCurrentlyPlaying(accessToken string) (string, error) {
-	req, err := http.NewRequest("GET", "https://api.spotify.com/v1/me/player/currently-playing", nil)
+// getJSON sends an authenticated GET request and decodes the JSON response into out
+func (c *Client) getJSON(ctx context.Context, accessToken, rawURL string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	resp, err := c.HTTPClient.Do(req)
+	_, err = c.do(req, out)
+	return err
+}
+
+// sendJSON sends an authenticated request with a JSON body and decodes the
+// JSON response into out, which may be nil
+func (c *Client) sendJSON(ctx context.Context, accessToken, method, rawURL string, body, out interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(encoded))
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer resp.Body.Close()
 
-	// No content means no track is playing
-	if resp.StatusCode == http.StatusNoContent {
-		return "", nil
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err = c.do(req, out)
+	return err
+}
+
+// do sends a request through the shared limiter and decodes a JSON response
+// into out. Rate limited requests are retried after Retry-After, and 5xx
+// responses of idempotent requests with exponential backoff and jitter.
+// Non-successful responses are returned as *Error.
+func (c *Client) do(req *http.Request, out interface{}) (int, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return 0, err
+			}
+			req.Body = body
+		}
+
+		if c.Limiter != nil {
+			if err := c.Limiter.Wait(req.Context()); err != nil {
+				return 0, err
+			}
+		}
+
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			return 0, err
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			defer resp.Body.Close()
+			if resp.StatusCode == http.StatusNoContent || out == nil {
+				return resp.StatusCode, nil
+			}
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+				return resp.StatusCode, err
+			}
+			return resp.StatusCode, nil
+		}
+
+		spotifyErr := newError(resp)
+		resp.Body.Close()
+
+		// Hold back every other request too, not just this one
+		if spotifyErr.RetryAfter > 0 && c.Limiter != nil {
+			c.Limiter.PauseUntil(time.Now().Add(spotifyErr.RetryAfter))
+		}
+
+		delay, retry := c.retryDelay(req, spotifyErr, attempt)
+		if !retry {
+			return resp.StatusCode, spotifyErr
+		}
+
+		fmt.Printf("[DEBUG] Spotify %s %s returned %d, retrying in %v\n", req.Method, req.URL.Path, resp.StatusCode, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return 0, req.Context().Err()
+		case <-timer.C:
+		}
 	}
+}
 
-	// Check for error response
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("spotify API request failed with status: %d", resp.StatusCode)
+// retryDelay decides whether a failed request is retried and after how long
+func (c *Client) retryDelay(req *http.Request, spotifyErr *Error, attempt int) (time.Duration, bool) {
+	if attempt >= c.MaxRetries {
+		return 0, false
 	}
 
-	var response struct {
-		Item struct {
-			Name    string `json:"name"`
-			Artists []struct {
-				Name string `json:"name"`
-			} `json:"artists"`
-		} `json:"item"`
+	switch {
+	case errors.Is(spotifyErr, ErrRateLimited):
+		delay := spotifyErr.RetryAfter
+		if delay == 0 {
+			delay = c.backoff(attempt)
+		}
+		if delay > c.MaxBackoff {
+			return 0, false
+		}
+		return delay, true
+	case errors.Is(spotifyErr, ErrUpstream):
+		// The request may have been applied, only retry when that is harmless
+		if req.Method != http.MethodGet && req.Method != http.MethodPut {
+			return 0, false
+		}
+		return c.backoff(attempt), true
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", err
+	return 0, false
+}
+
+// backoff returns an exponential delay for the attempt with jitter, capped at MaxBackoff
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.BaseBackoff << attempt
+	if delay <= 0 || delay > c.MaxBackoff {
+		delay = c.MaxBackoff
 	}
 
-	if response.Item.Name == "" {
-		return "", nil
+	// Use between half and all of the delay so retrying clients spread out
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// getPages walks an offset/limit paginated endpoint until limit items were
+// collected or there are no more pages. A limit of zero or less fetches everything.
+func getPages[T any](ctx context.Context, c *Client, accessToken, baseURL string, query url.Values, limit int) ([]T, error) {
+	pageSize := maxPageSize
+	if limit > 0 && limit < pageSize {
+		pageSize = limit
 	}
+	query.Set("limit", fmt.Sprint(pageSize))
+	query.Set("offset", "0")
+	next := baseURL + "?" + query.Encode()
+
+	var items []T
+	for next != "" {
+		var p page[T]
+		if err := c.getJSON(ctx, accessToken, next, &p); err != nil {
+			return nil, err
+		}
+
+		items = append(items, p.Items...)
+		if limit > 0 && len(items) >= limit {
+			return items[:limit], nil
+		}
 
-	artists := make([]string, len(response.Item.Artists))
-	for i, artist := range response.Item.Artists {
-		artists[i] = artist.Name
+		// Stop on empty pages so a misbehaving next link can't loop forever
+		if p.Next == nil || len(p.Items) == 0 {
+			break
+		}
+		next = *p.Next
 	}
 
-	track := fmt.Sprintf("%s - %s", response.Item.Name, strings.Join(artists, ", "))
-	return track, nil
+	return items, nil
 }
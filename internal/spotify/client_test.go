@@ -0,0 +1,733 @@
+package spotify_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matchmyvibe/backend/internal/spotify"
+	"github.com/matchmyvibe/backend/internal/spotify/spotifytest"
+)
+
+// newServer starts a fake Spotify server with one user and returns a client
+// for it that retries quickly
+func newServer(t *testing.T) (*spotifytest.Server, *spotify.Client, *spotifytest.User) {
+	t.Helper()
+
+	server := spotifytest.NewServer()
+	t.Cleanup(server.Close)
+
+	user := server.AddUser(spotifytest.User{ID: "alice", DisplayName: "Alice"})
+	client := server.Client(spotify.WithRetries(3, time.Millisecond, 5*time.Second))
+	return server, client, user
+}
+
+// countRequests counts the requests the fake server received for path
+func countRequests(server *spotifytest.Server, path string) int {
+	n := 0
+	for _, req := range server.Requests() {
+		if req.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+func TestExchangeCode(t *testing.T) {
+	server, client, _ := newServer(t)
+	ctx := context.Background()
+	server.AddAuthCode("code-1", "verifier-1", "alice")
+
+	tokens, err := client.ExchangeCode(ctx, "code-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("ExchangeCode() error = %v", err)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("ExchangeCode() = %+v, want access and refresh tokens", tokens)
+	}
+
+	req := server.Requests()[0]
+	if req.Form.Get("grant_type") != "authorization_code" || req.Form.Get("code_verifier") != "verifier-1" || req.Form.Get("client_id") != spotifytest.ClientID {
+		t.Errorf("token request form = %v, want a PKCE authorization_code grant", req.Form)
+	}
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		t.Errorf("token request Authorization = %q, want no client secret", auth)
+	}
+
+	// The issued access token works for the user
+	me, err := client.GetCurrentUser(ctx, tokens.AccessToken)
+	if err != nil || me.ID != "alice" {
+		t.Errorf("GetCurrentUser() = %+v, %v, want alice", me, err)
+	}
+
+	// Codes are single use
+	if _, err := client.ExchangeCode(ctx, "code-1", "verifier-1"); !errors.Is(err, spotify.ErrInvalidGrant) {
+		t.Errorf("reusing the code: error = %v, want ErrInvalidGrant", err)
+	}
+}
+
+func TestExchangeCodeWrongVerifier(t *testing.T) {
+	server, client, _ := newServer(t)
+	server.AddAuthCode("code-1", "verifier-1", "alice")
+
+	_, err := client.ExchangeCode(context.Background(), "code-1", "someone-elses-verifier")
+	if !errors.Is(err, spotify.ErrInvalidGrant) {
+		t.Fatalf("ExchangeCode() error = %v, want ErrInvalidGrant", err)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	server, client, user := newServer(t)
+	// The server updates the user it returned, keep the original tokens
+	oldAccess, oldRefresh := user.AccessToken, user.RefreshToken
+
+	tokens, err := client.RefreshToken(context.Background(), oldRefresh)
+	if err != nil {
+		t.Fatalf("RefreshToken() error = %v", err)
+	}
+	if tokens.AccessToken == "" || tokens.AccessToken == oldAccess {
+		t.Errorf("RefreshToken() access token = %q, want a new one", tokens.AccessToken)
+	}
+	if tokens.RefreshToken != "" {
+		t.Errorf("RefreshToken() refresh token = %q, want none without rotation", tokens.RefreshToken)
+	}
+
+	// Refreshes use the PKCE form like the code exchange
+	req := server.Requests()[0]
+	if req.Form.Get("grant_type") != "refresh_token" || req.Form.Get("refresh_token") != oldRefresh || req.Form.Get("client_id") != spotifytest.ClientID {
+		t.Errorf("token request form = %v, want a PKCE refresh_token grant", req.Form)
+	}
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		t.Errorf("token request Authorization = %q, want no client secret", auth)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	server, client, user := newServer(t)
+	ctx := context.Background()
+	server.RotateRefreshTokens = true
+	oldRefresh := user.RefreshToken
+
+	tokens, err := client.RefreshToken(ctx, oldRefresh)
+	if err != nil {
+		t.Fatalf("RefreshToken() error = %v", err)
+	}
+	if tokens.RefreshToken == "" || tokens.RefreshToken == oldRefresh {
+		t.Fatalf("RefreshToken() refresh token = %q, want a rotated one", tokens.RefreshToken)
+	}
+
+	if _, err := client.RefreshToken(ctx, oldRefresh); !errors.Is(err, spotify.ErrInvalidGrant) {
+		t.Errorf("refreshing with the old token: error = %v, want ErrInvalidGrant", err)
+	}
+	if _, err := client.RefreshToken(ctx, tokens.RefreshToken); err != nil {
+		t.Errorf("refreshing with the rotated token: error = %v", err)
+	}
+}
+
+func TestRefreshTokenRevoked(t *testing.T) {
+	server, client, user := newServer(t)
+	server.RevokeRefreshToken(user.RefreshToken)
+
+	_, err := client.RefreshToken(context.Background(), user.RefreshToken)
+	if !errors.Is(err, spotify.ErrInvalidGrant) {
+		t.Fatalf("RefreshToken() error = %v, want ErrInvalidGrant", err)
+	}
+}
+
+func TestGetCurrentUser(t *testing.T) {
+	_, client, user := newServer(t)
+
+	me, err := client.GetCurrentUser(context.Background(), user.AccessToken)
+	if err != nil {
+		t.Fatalf("GetCurrentUser() error = %v", err)
+	}
+	if me.ID != "alice" || me.DisplayName != "Alice" || me.URI != "spotify:user:alice" {
+		t.Errorf("GetCurrentUser() = %+v", me)
+	}
+}
+
+func TestGetCurrentUserUnauthorized(t *testing.T) {
+	server, client, _ := newServer(t)
+
+	_, err := client.GetCurrentUser(context.Background(), "not-a-token")
+	if !errors.Is(err, spotify.ErrUnauthorized) {
+		t.Fatalf("GetCurrentUser() error = %v, want ErrUnauthorized", err)
+	}
+	// Unauthorized requests are not retried
+	if n := countRequests(server, "/v1/me"); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestGetCurrentlyPlaying(t *testing.T) {
+	server, client, user := newServer(t)
+	ctx := context.Background()
+
+	playing, err := client.GetCurrentlyPlaying(ctx, user.AccessToken)
+	if err != nil || playing != nil {
+		t.Fatalf("GetCurrentlyPlaying() = %+v, %v, want nothing playing", playing, err)
+	}
+
+	server.UpdateUser("alice", func(u *spotifytest.User) {
+		u.CurrentlyPlaying = &spotifytest.Playback{
+			IsPlaying:  true,
+			ProgressMS: 1000,
+			Item: &spotifytest.Track{
+				ID:         "t1",
+				Name:       "Song",
+				URI:        "spotify:track:t1",
+				DurationMS: 180000,
+				Artists:    []spotifytest.Artist{{Name: "Artist A"}, {Name: "Artist B"}},
+			},
+		}
+	})
+	playing, err = client.GetCurrentlyPlaying(ctx, user.AccessToken)
+	if err != nil {
+		t.Fatalf("GetCurrentlyPlaying() error = %v", err)
+	}
+	if playing == nil || playing.Item == nil || playing.Item.URI != "spotify:track:t1" || playing.Type != spotify.PlayingTypeTrack || !playing.IsPlaying {
+		t.Fatalf("GetCurrentlyPlaying() = %+v, want the track", playing)
+	}
+
+	server.UpdateUser("alice", func(u *spotifytest.User) {
+		u.CurrentlyPlaying = &spotifytest.Playback{
+			IsPlaying:  true,
+			ProgressMS: 1000,
+			Episode:    &spotifytest.Episode{ID: "ep1", Name: "Episode 1", URI: "spotify:episode:ep1", DurationMS: 60000},
+		}
+	})
+	playing, err = client.GetCurrentlyPlaying(ctx, user.AccessToken)
+	if err != nil {
+		t.Fatalf("GetCurrentlyPlaying() error = %v", err)
+	}
+	if playing == nil || playing.Item == nil || playing.Item.URI != "spotify:episode:ep1" || playing.Type != spotify.PlayingTypeEpisode {
+		t.Fatalf("GetCurrentlyPlaying() = %+v, want the episode", playing)
+	}
+
+	// The currently playing endpoint only needs user-read-currently-playing,
+	// unlike the full playback state
+	if n := countRequests(server, "/v1/me/player/currently-playing"); n != 3 {
+		t.Errorf("currently playing requests = %d, want 3", n)
+	}
+	if n := countRequests(server, "/v1/me/player"); n != 0 {
+		t.Errorf("playback state requests = %d, want 0", n)
+	}
+}
+
+func addTopItems(server *spotifytest.Server, n int) {
+	server.UpdateUser("alice", func(u *spotifytest.User) {
+		for i := 0; i < n; i++ {
+			id := fmt.Sprint(i)
+			u.TopArtists = append(u.TopArtists, spotifytest.Artist{ID: id, Name: "Artist " + id, URI: "spotify:artist:" + id})
+			u.TopTracks = append(u.TopTracks, spotifytest.Track{ID: id, Name: "Track " + id, URI: "spotify:track:" + id})
+		}
+	})
+}
+
+func TestGetTopArtistsFollowsNextLinks(t *testing.T) {
+	server, client, user := newServer(t)
+	addTopItems(server, 120)
+
+	artists, err := client.GetTopArtists(context.Background(), user.AccessToken, "medium_term", 0)
+	if err != nil {
+		t.Fatalf("GetTopArtists() error = %v", err)
+	}
+	if len(artists) != 120 {
+		t.Fatalf("GetTopArtists() = %d artists, want 120", len(artists))
+	}
+	for i, artist := range artists {
+		if artist.URI != fmt.Sprintf("spotify:artist:%d", i) {
+			t.Fatalf("artists[%d] = %s, want them in rank order", i, artist.URI)
+		}
+	}
+
+	// Pages of 50 are fetched by following the next links
+	reqs := server.Requests()
+	if len(reqs) != 3 {
+		t.Fatalf("requests = %d, want 3", len(reqs))
+	}
+	for i, req := range reqs {
+		if got, want := req.Query.Get("offset"), fmt.Sprint(i*50); got != want || req.Query.Get("limit") != "50" {
+			t.Errorf("request %d query = %v, want offset %s and limit 50", i, req.Query, want)
+		}
+		if req.Query.Get("time_range") != "medium_term" {
+			t.Errorf("request %d lost the time_range: %v", i, req.Query)
+		}
+	}
+}
+
+func TestGetTopTracksStopsAtLimit(t *testing.T) {
+	server, client, user := newServer(t)
+	ctx := context.Background()
+	addTopItems(server, 120)
+
+	tracks, err := client.GetTopTracks(ctx, user.AccessToken, "short_term", 70)
+	if err != nil {
+		t.Fatalf("GetTopTracks() error = %v", err)
+	}
+	if len(tracks) != 70 || tracks[69].URI != "spotify:track:69" {
+		t.Fatalf("GetTopTracks() = %d tracks, want the first 70", len(tracks))
+	}
+	if n := countRequests(server, "/v1/me/top/tracks"); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+
+	// A limit below the page size asks for exactly that many
+	if _, err := client.GetTopTracks(ctx, user.AccessToken, "short_term", 10); err != nil {
+		t.Fatalf("GetTopTracks() error = %v", err)
+	}
+	reqs := server.Requests()
+	if last := reqs[len(reqs)-1]; last.Query.Get("limit") != "10" {
+		t.Errorf("request query = %v, want limit 10", last.Query)
+	}
+}
+
+func TestGetTopArtistsEmpty(t *testing.T) {
+	server, client, user := newServer(t)
+
+	artists, err := client.GetTopArtists(context.Background(), user.AccessToken, "long_term", 0)
+	if err != nil || len(artists) != 0 {
+		t.Fatalf("GetTopArtists() = %v, %v, want no artists", artists, err)
+	}
+	if n := countRequests(server, "/v1/me/top/artists"); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestGetUserPlaylists(t *testing.T) {
+	server, client, user := newServer(t)
+	server.UpdateUser("alice", func(u *spotifytest.User) {
+		for i := 0; i < 60; i++ {
+			id := fmt.Sprint(i)
+			u.Playlists = append(u.Playlists, spotifytest.Playlist{ID: id, Name: "Playlist " + id, URI: "spotify:playlist:" + id})
+		}
+	})
+
+	playlists, err := client.GetUserPlaylists(context.Background(), user.AccessToken)
+	if err != nil {
+		t.Fatalf("GetUserPlaylists() error = %v", err)
+	}
+	if len(playlists) != 60 || playlists[59].URI != "spotify:playlist:59" {
+		t.Fatalf("GetUserPlaylists() = %d playlists, want all 60", len(playlists))
+	}
+	if n := countRequests(server, "/v1/me/playlists"); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+}
+
+func TestGetUserPlaylistsSkipsNullEntries(t *testing.T) {
+	server, client, user := newServer(t)
+	server.Fail("/v1/me/playlists", spotifytest.Failure{
+		Status: http.StatusOK,
+		Body:   `{"items": [{"id": "p1", "name": "Kept", "uri": "spotify:playlist:p1"}, null], "next": null}`,
+		Times:  1,
+	})
+
+	playlists, err := client.GetUserPlaylists(context.Background(), user.AccessToken)
+	if err != nil {
+		t.Fatalf("GetUserPlaylists() error = %v", err)
+	}
+	if len(playlists) != 1 || playlists[0].ID != "p1" {
+		t.Errorf("GetUserPlaylists() = %+v, want only p1", playlists)
+	}
+}
+
+func TestBestImageURL(t *testing.T) {
+	size := func(n int) *int { return &n }
+	images := []spotify.Image{
+		{URL: "large", Width: size(640), Height: size(640)},
+		{URL: "medium", Width: size(300), Height: size(300)},
+		{URL: "small", Width: size(64), Height: size(64)},
+	}
+
+	tests := []struct {
+		name    string
+		images  []spotify.Image
+		minSize int
+		want    string
+	}{
+		{"smallest big enough", images, 200, "medium"},
+		{"largest when none is big enough", images, 1000, "large"},
+		{"unknown dimensions", []spotify.Image{{URL: "cover"}}, 200, "cover"},
+		{"no images", nil, 200, ""},
+	}
+	for _, tt := range tests {
+		if got := spotify.BestImageURL(tt.images, tt.minSize); got != tt.want {
+			t.Errorf("%s: BestImageURL() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitedRetriesAfterRetryAfter(t *testing.T) {
+	server, client, user := newServer(t)
+	server.Fail("/v1/me", spotifytest.Failure{Status: http.StatusTooManyRequests, RetryAfter: 1, Times: 1})
+
+	start := time.Now()
+	me, err := client.GetCurrentUser(context.Background(), user.AccessToken)
+	if err != nil {
+		t.Fatalf("GetCurrentUser() error = %v", err)
+	}
+	if me.ID != "alice" {
+		t.Errorf("GetCurrentUser() = %+v, want alice", me)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least the Retry-After of 1s", elapsed)
+	}
+	if n := countRequests(server, "/v1/me"); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+}
+
+func TestRateLimitedLongerThanMaxBackoff(t *testing.T) {
+	server := spotifytest.NewServer()
+	defer server.Close()
+	user := server.AddUser(spotifytest.User{ID: "alice"})
+	client := server.Client(spotify.WithRetries(3, time.Millisecond, 10*time.Millisecond))
+	server.Fail("/v1/me", spotifytest.Failure{Status: http.StatusTooManyRequests, RetryAfter: 30})
+
+	_, err := client.GetCurrentUser(context.Background(), user.AccessToken)
+	if !errors.Is(err, spotify.ErrRateLimited) {
+		t.Fatalf("GetCurrentUser() error = %v, want ErrRateLimited", err)
+	}
+	if retryAfter := spotify.RetryAfter(err); retryAfter != 30*time.Second {
+		t.Errorf("RetryAfter() = %v, want 30s", retryAfter)
+	}
+	if n := countRequests(server, "/v1/me"); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestUpstreamErrorsAreRetried(t *testing.T) {
+	server, client, user := newServer(t)
+	server.Fail("/v1/me", spotifytest.Failure{Status: http.StatusServiceUnavailable, Times: 2})
+
+	me, err := client.GetCurrentUser(context.Background(), user.AccessToken)
+	if err != nil {
+		t.Fatalf("GetCurrentUser() error = %v", err)
+	}
+	if me.ID != "alice" {
+		t.Errorf("GetCurrentUser() = %+v, want alice", me)
+	}
+	if n := countRequests(server, "/v1/me"); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestUpstreamErrorsGiveUpAfterMaxRetries(t *testing.T) {
+	server, client, user := newServer(t)
+	server.Fail("/v1/me", spotifytest.Failure{Status: http.StatusBadGateway})
+
+	_, err := client.GetCurrentUser(context.Background(), user.AccessToken)
+	if !errors.Is(err, spotify.ErrUpstream) {
+		t.Fatalf("GetCurrentUser() error = %v, want ErrUpstream", err)
+	}
+	if n := countRequests(server, "/v1/me"); n != 4 {
+		t.Errorf("requests = %d, want the first try and 3 retries", n)
+	}
+}
+
+func TestUpstreamErrorsOfPostsAreNotRetried(t *testing.T) {
+	server, client, user := newServer(t)
+	server.Fail("/api/token", spotifytest.Failure{Status: http.StatusInternalServerError, Times: 1})
+
+	_, err := client.RefreshToken(context.Background(), user.RefreshToken)
+	if !errors.Is(err, spotify.ErrUpstream) {
+		t.Fatalf("RefreshToken() error = %v, want ErrUpstream", err)
+	}
+	if n := countRequests(server, "/api/token"); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestForbiddenIsClassifiedByMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		want    error
+	}{
+		{"Insufficient client scope", spotify.ErrScopeMissing},
+		{"Player command failed: Premium required", spotify.ErrForbidden},
+		{"User not registered in the Developer Dashboard", spotify.ErrForbidden},
+		{"", spotify.ErrForbidden},
+	}
+	for _, tt := range tests {
+		server, client, user := newServer(t)
+		body := fmt.Sprintf(`{"error": {"status": 403, "message": %q}}`, tt.message)
+		server.Fail("/v1/me", spotifytest.Failure{Status: http.StatusForbidden, Body: body})
+
+		_, err := client.GetCurrentUser(context.Background(), user.AccessToken)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("403 %q: error = %v, want %v", tt.message, err, tt.want)
+		}
+	}
+}
+
+func TestNewLimiterClampsBadRates(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		limiter := spotify.NewLimiter(rate, 1)
+
+		// The burst is used up by the first request, the second has to wait
+		// for a token at the minimum rate instead of forever
+		done := make(chan struct{})
+		go func() {
+			limiter.Wait(context.Background())
+			limiter.Wait(context.Background())
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("NewLimiter(%v, 1) never hands out a second token", rate)
+		}
+	}
+}
+
+func TestLimiterWaitStopsWithContext(t *testing.T) {
+	limiter := spotify.NewLimiter(1, 1)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want DeadlineExceeded", err)
+	}
+}
+
+// addPlays gives the user n plays a minute apart starting at start
+func addPlays(server *spotifytest.Server, start time.Time, n int) {
+	server.UpdateUser("alice", func(u *spotifytest.User) {
+		for i := 0; i < n; i++ {
+			id := fmt.Sprint(i)
+			u.RecentlyPlayed = append(u.RecentlyPlayed, spotifytest.PlayHistory{
+				Track:    spotifytest.Track{ID: id, Name: "Track " + id, URI: "spotify:track:" + id},
+				PlayedAt: start.Add(time.Duration(i) * time.Minute),
+			})
+		}
+	})
+}
+
+func TestGetRecentlyPlayedWalksPages(t *testing.T) {
+	server, client, user := newServer(t)
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	addPlays(server, start, 120)
+
+	plays, cursor, err := client.GetRecentlyPlayed(ctx, user.AccessToken, 0)
+	if err != nil {
+		t.Fatalf("GetRecentlyPlayed() error = %v", err)
+	}
+	if len(plays) != 120 {
+		t.Fatalf("GetRecentlyPlayed() = %d plays, want 120", len(plays))
+	}
+	for i, play := range plays {
+		if play.Track.URI != fmt.Sprintf("spotify:track:%d", i) {
+			t.Fatalf("plays[%d] = %s, want them oldest first", i, play.Track.URI)
+		}
+	}
+	if want := start.Add(119 * time.Minute).UnixMilli(); cursor != want {
+		t.Errorf("cursor = %d, want the newest play %d", cursor, want)
+	}
+	if n := countRequests(server, "/v1/me/player/recently-played"); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+
+	// The cursor picks up only newer plays
+	addPlays(server, start.Add(time.Hour*3), 1)
+	plays, next, err := client.GetRecentlyPlayed(ctx, user.AccessToken, cursor)
+	if err != nil {
+		t.Fatalf("GetRecentlyPlayed() error = %v", err)
+	}
+	if len(plays) != 1 || next != start.Add(time.Hour*3).UnixMilli() {
+		t.Errorf("GetRecentlyPlayed(cursor) = %d plays, cursor %d, want the one new play", len(plays), next)
+	}
+}
+
+func TestGetRecentlyPlayedNothingNew(t *testing.T) {
+	_, client, user := newServer(t)
+
+	plays, cursor, err := client.GetRecentlyPlayed(context.Background(), user.AccessToken, 1234)
+	if err != nil || len(plays) != 0 || cursor != 1234 {
+		t.Errorf("GetRecentlyPlayed() = %d plays, cursor %d, %v, want none and the same cursor", len(plays), cursor, err)
+	}
+}
+
+func TestGetArtistsBatches(t *testing.T) {
+	server, client, user := newServer(t)
+
+	ids := make([]string, 120)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i)
+		// Leave one artist out of the catalog, Spotify answers null for it
+		if i != 60 {
+			server.AddArtists(spotifytest.Artist{ID: ids[i], Name: "Artist " + ids[i], URI: "spotify:artist:" + ids[i], Genres: []string{"indie"}})
+		}
+	}
+
+	artists, err := client.GetArtists(context.Background(), user.AccessToken, ids)
+	if err != nil {
+		t.Fatalf("GetArtists() error = %v", err)
+	}
+	if len(artists) != 119 {
+		t.Fatalf("GetArtists() = %d artists, want 119", len(artists))
+	}
+	if artists[0].ID != "0" || len(artists[0].Genres) != 1 || artists[60].ID != "61" {
+		t.Errorf("GetArtists() = %+v ..., want the known artists in order with genres", artists[0])
+	}
+
+	// At most 50 ids are sent per request
+	reqs := server.Requests()
+	if len(reqs) != 3 {
+		t.Fatalf("requests = %d, want 3", len(reqs))
+	}
+	for i, want := range []int{50, 50, 20} {
+		if got := len(strings.Split(reqs[i].Query.Get("ids"), ",")); got != want {
+			t.Errorf("request %d asked for %d ids, want %d", i, got, want)
+		}
+	}
+}
+
+func TestGetArtistsNone(t *testing.T) {
+	server, client, user := newServer(t)
+
+	artists, err := client.GetArtists(context.Background(), user.AccessToken, nil)
+	if err != nil || len(artists) != 0 {
+		t.Errorf("GetArtists(nil) = %v, %v, want no artists", artists, err)
+	}
+	if n := len(server.Requests()); n != 0 {
+		t.Errorf("requests = %d, want 0", n)
+	}
+}
+
+func TestGetAudioFeaturesBatches(t *testing.T) {
+	server, client, user := newServer(t)
+
+	ids := make([]string, 250)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i)
+		// Spotify answers null for tracks without audio features
+		if i%50 != 0 {
+			server.AddAudioFeatures(spotifytest.AudioFeatures{ID: ids[i], URI: "spotify:track:" + ids[i], Energy: 0.5})
+		}
+	}
+
+	features, err := client.GetAudioFeatures(context.Background(), user.AccessToken, ids)
+	if err != nil {
+		t.Fatalf("GetAudioFeatures() error = %v", err)
+	}
+	if len(features) != 245 {
+		t.Fatalf("GetAudioFeatures() = %d features, want 245", len(features))
+	}
+	if features[0].ID != "1" || features[0].Energy != 0.5 {
+		t.Errorf("features[0] = %+v, want track 1", features[0])
+	}
+
+	// At most 100 ids are sent per request
+	reqs := server.Requests()
+	if len(reqs) != 3 {
+		t.Fatalf("requests = %d, want 3", len(reqs))
+	}
+	for i, want := range []int{100, 100, 50} {
+		if got := len(strings.Split(reqs[i].Query.Get("ids"), ",")); got != want {
+			t.Errorf("request %d asked for %d ids, want %d", i, got, want)
+		}
+	}
+}
+
+// statusCode returns the HTTP status of a Spotify error, or 0
+func statusCode(err error) int {
+	var spotifyErr *spotify.Error
+	if errors.As(err, &spotifyErr) {
+		return spotifyErr.StatusCode
+	}
+	return 0
+}
+
+// trackURIs returns n distinct track URIs starting at first
+func trackURIs(first, n int) []string {
+	uris := make([]string, n)
+	for i := range uris {
+		uris[i] = fmt.Sprintf("spotify:track:%d", first+i)
+	}
+	return uris
+}
+
+func TestCreatePlaylistAndAddItems(t *testing.T) {
+	server, client, user := newServer(t)
+	ctx := context.Background()
+
+	playlist, err := client.CreatePlaylist(ctx, user.AccessToken, "alice", "Alice + Bob", "Blended", false)
+	if err != nil {
+		t.Fatalf("CreatePlaylist() error = %v", err)
+	}
+	if playlist.ID == "" || playlist.URI != "spotify:playlist:"+playlist.ID || playlist.Public {
+		t.Errorf("CreatePlaylist() = %+v, want a private playlist", playlist)
+	}
+
+	// More items than fit in one request are sent in batches of 100
+	uris := trackURIs(0, 150)
+	if err := client.AddPlaylistItems(ctx, user.AccessToken, playlist.ID, uris); err != nil {
+		t.Fatalf("AddPlaylistItems() error = %v", err)
+	}
+	if got := server.PlaylistItems(playlist.ID); !reflect.DeepEqual(got, uris) {
+		t.Errorf("playlist items = %d items, want %d in order", len(got), len(uris))
+	}
+	if n := countRequests(server, "/v1/playlists/"+playlist.ID+"/tracks"); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+}
+
+func TestReplacePlaylistItems(t *testing.T) {
+	server, client, user := newServer(t)
+	ctx := context.Background()
+
+	playlist, err := client.CreatePlaylist(ctx, user.AccessToken, "alice", "Blend", "", false)
+	if err != nil {
+		t.Fatalf("CreatePlaylist() error = %v", err)
+	}
+	if err := client.AddPlaylistItems(ctx, user.AccessToken, playlist.ID, trackURIs(0, 20)); err != nil {
+		t.Fatalf("AddPlaylistItems() error = %v", err)
+	}
+
+	replacement := trackURIs(1000, 120)
+	if err := client.ReplacePlaylistItems(ctx, user.AccessToken, playlist.ID, replacement); err != nil {
+		t.Fatalf("ReplacePlaylistItems() error = %v", err)
+	}
+	if got := server.PlaylistItems(playlist.ID); !reflect.DeepEqual(got, replacement) {
+		t.Errorf("playlist items = %v, want the replacement", got)
+	}
+
+	if err := client.ReplacePlaylistItems(ctx, user.AccessToken, playlist.ID, nil); err != nil {
+		t.Fatalf("ReplacePlaylistItems(nil) error = %v", err)
+	}
+	if got := server.PlaylistItems(playlist.ID); len(got) != 0 {
+		t.Errorf("playlist items = %v, want none", got)
+	}
+}
+
+func TestPlaylistErrors(t *testing.T) {
+	server, client, alice := newServer(t)
+	bob := server.AddUser(spotifytest.User{ID: "bob"})
+	ctx := context.Background()
+
+	if _, err := client.CreatePlaylist(ctx, alice.AccessToken, "bob", "Blend", "", false); !errors.Is(err, spotify.ErrForbidden) {
+		t.Errorf("creating a playlist for another user: error = %v, want ErrForbidden", err)
+	}
+
+	playlist, err := client.CreatePlaylist(ctx, alice.AccessToken, "alice", "Blend", "", false)
+	if err != nil {
+		t.Fatalf("CreatePlaylist() error = %v", err)
+	}
+	if err := client.ReplacePlaylistItems(ctx, bob.AccessToken, playlist.ID, trackURIs(0, 1)); !errors.Is(err, spotify.ErrForbidden) {
+		t.Errorf("replacing another user's playlist: error = %v, want ErrForbidden", err)
+	}
+	if err := client.AddPlaylistItems(ctx, alice.AccessToken, "missing", trackURIs(0, 1)); statusCode(err) != http.StatusNotFound {
+		t.Errorf("adding to a missing playlist: error = %v, want 404", err)
+	}
+}
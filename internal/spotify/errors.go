@@ -0,0 +1,145 @@
+package spotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error kinds returned by the client. Use errors.Is to check for them and
+// errors.As with *Error to read the status code or Retry-After duration.
+var (
+	// ErrUnauthorized means the access token is expired, revoked or invalid
+	ErrUnauthorized = errors.New("spotify: unauthorized")
+	// ErrScopeMissing means the token lacks a scope the endpoint requires
+	ErrScopeMissing = errors.New("spotify: missing scope")
+	// ErrForbidden means Spotify refused the request for another reason than
+	// a missing scope, e.g. Premium is required or the user isn't registered
+	// with an app in development mode
+	ErrForbidden = errors.New("spotify: forbidden")
+	// ErrRateLimited means Spotify answered 429 Too Many Requests
+	ErrRateLimited = errors.New("spotify: rate limited")
+	// ErrUpstream means Spotify failed with a 5xx status
+	ErrUpstream = errors.New("spotify: upstream error")
+	// ErrInvalidGrant means the accounts service rejected a refresh token or
+	// authorization code, typically because the user revoked access
+	ErrInvalidGrant = errors.New("spotify: invalid grant")
+	// ErrBadRequest means Spotify rejected the request with another 4xx status
+	ErrBadRequest = errors.New("spotify: bad request")
+)
+
+// Error is returned for every non-successful Spotify response
+type Error struct {
+	// Kind is one of the Err* values above
+	Kind error
+	// StatusCode is the HTTP status Spotify answered with
+	StatusCode int
+	// Message is the error message from the response body, if any
+	Message string
+	// RetryAfter is how long Spotify asked us to wait before retrying
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%v (status %d): %s", e.Kind, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%v (status %d)", e.Kind, e.StatusCode)
+}
+
+// Unwrap lets errors.Is match the error kind
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// RetryAfter returns how long Spotify asked the caller to wait, or zero if the
+// error is not a rate limit error
+func RetryAfter(err error) time.Duration {
+	var spotifyErr *Error
+	if errors.As(err, &spotifyErr) {
+		return spotifyErr.RetryAfter
+	}
+	return 0
+}
+
+// newError builds an Error from a non-successful response
+func newError(resp *http.Response) *Error {
+	e := &Error{StatusCode: resp.StatusCode}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	code, message := parseErrorBody(body)
+	e.Message = message
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest && code == "invalid_grant":
+		e.Kind = ErrInvalidGrant
+	case resp.StatusCode == http.StatusUnauthorized:
+		e.Kind = ErrUnauthorized
+	case resp.StatusCode == http.StatusForbidden && isScopeError(e.Message):
+		e.Kind = ErrScopeMissing
+	case resp.StatusCode == http.StatusForbidden:
+		e.Kind = ErrForbidden
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Kind = ErrRateLimited
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode >= 500:
+		e.Kind = ErrUpstream
+	default:
+		e.Kind = ErrBadRequest
+	}
+	return e
+}
+
+// parseErrorBody extracts the OAuth error code and the message from either the
+// Web API error format ({"error": {"status": 401, "message": "..."}}) or the
+// accounts service format ({"error": "invalid_grant", "error_description": "..."})
+func parseErrorBody(body []byte) (code, message string) {
+	var apiError struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &apiError); err == nil && apiError.Error.Message != "" {
+		return "", apiError.Error.Message
+	}
+
+	var oauthError struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &oauthError); err == nil && oauthError.Error != "" {
+		if oauthError.Description != "" {
+			return oauthError.Error, oauthError.Error + ": " + oauthError.Description
+		}
+		return oauthError.Error, oauthError.Error
+	}
+
+	return "", ""
+}
+
+// isScopeError reports whether a 403 message blames the token's scopes, like
+// "Insufficient client scope"
+func isScopeError(message string) bool {
+	return strings.Contains(strings.ToLower(message), "scope")
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,847 @@
+// Package spotifytest provides an in-process fake of the Spotify accounts
+// service and Web API so code using spotify.Client can run without network.
+package spotifytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matchmyvibe/backend/internal/spotify"
+)
+
+// Image represents an image object as returned by the Spotify API
+type Image struct {
+	URL    string `json:"url"`
+	Height int    `json:"height,omitempty"`
+	Width  int    `json:"width,omitempty"`
+}
+
+// Artist represents an artist object as returned by the Spotify API
+type Artist struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	URI        string   `json:"uri"`
+	Genres     []string `json:"genres,omitempty"`
+	Popularity int      `json:"popularity,omitempty"`
+	Images     []Image  `json:"images,omitempty"`
+}
+
+// Album represents an album object as returned by the Spotify API
+type Album struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	URI    string  `json:"uri"`
+	Images []Image `json:"images,omitempty"`
+}
+
+// Show represents a podcast show object as returned by the Spotify API
+type Show struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	URI       string  `json:"uri"`
+	Publisher string  `json:"publisher"`
+	Images    []Image `json:"images,omitempty"`
+}
+
+// Track represents a track object as returned by the Spotify API
+type Track struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	URI        string   `json:"uri"`
+	Type       string   `json:"type"`
+	DurationMS int      `json:"duration_ms"`
+	Artists    []Artist `json:"artists"`
+	Album      Album    `json:"album"`
+}
+
+// Episode represents a podcast episode object as returned by the Spotify API
+type Episode struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	URI        string  `json:"uri"`
+	Type       string  `json:"type"`
+	DurationMS int     `json:"duration_ms"`
+	Images     []Image `json:"images,omitempty"`
+	Show       Show    `json:"show"`
+}
+
+// Playlist represents a simplified playlist object as returned by the Spotify API
+type Playlist struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	URI    string  `json:"uri"`
+	Public bool    `json:"public"`
+	Images []Image `json:"images"`
+}
+
+// Context represents the context a track is played from
+type Context struct {
+	Type string `json:"type"`
+	URI  string `json:"uri"`
+}
+
+// PlayHistory represents one play in the user's recently played tracks
+type PlayHistory struct {
+	Track    Track     `json:"track"`
+	PlayedAt time.Time `json:"played_at"`
+	Context  *Context  `json:"context"`
+}
+
+// AudioFeatures represents an audio features object as returned by the Spotify API
+type AudioFeatures struct {
+	ID               string  `json:"id"`
+	URI              string  `json:"uri"`
+	Energy           float64 `json:"energy"`
+	Valence          float64 `json:"valence"`
+	Danceability     float64 `json:"danceability"`
+	Acousticness     float64 `json:"acousticness"`
+	Instrumentalness float64 `json:"instrumentalness"`
+	Tempo            float64 `json:"tempo"`
+}
+
+// Device represents a playback device as returned by the Spotify API
+type Device struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	IsActive      bool   `json:"is_active"`
+	VolumePercent int    `json:"volume_percent"`
+}
+
+// Playback represents the playback state as returned by the Spotify API. Set
+// Item for tracks or Episode for podcast episodes.
+type Playback struct {
+	Timestamp            int64    `json:"timestamp"`
+	ProgressMS           int      `json:"progress_ms"`
+	IsPlaying            bool     `json:"is_playing"`
+	CurrentlyPlayingType string   `json:"currently_playing_type"`
+	Item                 *Track   `json:"-"`
+	Episode              *Episode `json:"-"`
+	Context              *Context `json:"context"`
+	Device               *Device  `json:"device,omitempty"`
+}
+
+// MarshalJSON puts the track or episode into the item field like Spotify does
+func (p Playback) MarshalJSON() ([]byte, error) {
+	type playback Playback
+	out := struct {
+		playback
+		Item interface{} `json:"item"`
+	}{playback: playback(p)}
+
+	switch {
+	case p.Episode != nil:
+		episode := *p.Episode
+		if episode.Type == "" {
+			episode.Type = "episode"
+		}
+		out.Item = episode
+	case p.Item != nil:
+		out.Item = withTrackType(*p.Item)
+	}
+	return json.Marshal(out)
+}
+
+// User is a Spotify account known to the fake server. Tokens left empty are
+// generated by AddUser.
+type User struct {
+	ID           string
+	DisplayName  string
+	AccessToken  string
+	RefreshToken string
+
+	CurrentlyPlaying *Playback
+	TopArtists       []Artist
+	TopTracks        []Track
+	Playlists        []Playlist
+	RecentlyPlayed   []PlayHistory
+}
+
+// Failure scripts an error response for a path
+type Failure struct {
+	// Status is the HTTP status code to respond with
+	Status int
+	// RetryAfter is sent as the Retry-After header in seconds when non-zero
+	RetryAfter int
+	// Body is sent as the response body, a Spotify style error is used when empty
+	Body string
+	// Times is how many requests fail before the path recovers, zero means forever
+	Times int
+}
+
+// Request records a request received by the fake server
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Query  url.Values
+	Form   url.Values
+}
+
+// createdPlaylist is a playlist created through the fake server
+type createdPlaylist struct {
+	ownerID string
+	items   []string
+}
+
+type authCode struct {
+	userID       string
+	codeVerifier string
+}
+
+// ClientID is the only client ID the fake accounts service accepts
+const ClientID = "test-client-id"
+
+// Server is a fake Spotify accounts service and Web API backed by httptest
+type Server struct {
+	*httptest.Server
+
+	// RotateRefreshTokens makes refreshes return a new refresh token and revoke
+	// the old one. By default refresh responses omit refresh_token like Spotify
+	// usually does.
+	RotateRefreshTokens bool
+	// TokenLifetime is the expires_in returned for issued access tokens
+	TokenLifetime time.Duration
+
+	mu            sync.Mutex
+	users         map[string]*User
+	accessTokens  map[string]string
+	refreshTokens map[string]string
+	codes         map[string]authCode
+	artists       map[string]Artist
+	audioFeatures map[string]AudioFeatures
+	playlists     map[string]*createdPlaylist
+	failures      map[string]*Failure
+	requests      []Request
+	tokenSeq      int
+}
+
+// NewServer starts a new fake Spotify server. Callers must Close it.
+func NewServer() *Server {
+	s := &Server{
+		TokenLifetime: time.Hour,
+		users:         make(map[string]*User),
+		accessTokens:  make(map[string]string),
+		refreshTokens: make(map[string]string),
+		codes:         make(map[string]authCode),
+		artists:       make(map[string]Artist),
+		audioFeatures: make(map[string]AudioFeatures),
+		playlists:     make(map[string]*createdPlaylist),
+		failures:      make(map[string]*Failure),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/token", s.handleToken)
+	mux.HandleFunc("/v1/artists", s.withUser(s.handleArtists))
+	mux.HandleFunc("/v1/audio-features", s.withUser(s.handleAudioFeatures))
+	mux.HandleFunc("/v1/me", s.withUser(s.handleMe))
+	mux.HandleFunc("/v1/me/player", s.withUser(s.handlePlayer))
+	mux.HandleFunc("/v1/me/player/currently-playing", s.withUser(s.handleCurrentlyPlaying))
+	mux.HandleFunc("/v1/me/player/recently-played", s.withUser(s.handleRecentlyPlayed))
+	mux.HandleFunc("/v1/me/top/artists", s.withUser(s.handleTopArtists))
+	mux.HandleFunc("/v1/me/top/tracks", s.withUser(s.handleTopTracks))
+	mux.HandleFunc("/v1/me/playlists", s.withUser(s.handlePlaylists))
+	mux.HandleFunc("/v1/users/{userID}/playlists", s.withUser(s.handleCreatePlaylist))
+	mux.HandleFunc("/v1/playlists/{playlistID}/tracks", s.withUser(s.handlePlaylistItems))
+
+	s.Server = httptest.NewServer(s.recordAndFail(mux))
+	return s
+}
+
+// Client returns a spotify.Client that talks to the fake server
+func (s *Server) Client(opts ...spotify.Option) *spotify.Client {
+	opts = append([]spotify.Option{
+		spotify.WithAccountsURL(s.URL),
+		spotify.WithAPIURL(s.URL),
+		spotify.WithHTTPClient(s.Server.Client()),
+	}, opts...)
+	return spotify.New(ClientID, "test-client-secret", "http://localhost/callback", opts...)
+}
+
+// AddUser registers a Spotify account and returns the stored copy
+func (s *Server) AddUser(u User) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u.AccessToken == "" {
+		u.AccessToken = s.nextToken("access")
+	}
+	if u.RefreshToken == "" {
+		u.RefreshToken = s.nextToken("refresh")
+	}
+
+	for _, artist := range u.TopArtists {
+		s.artists[artist.ID] = artist
+	}
+
+	user := u
+	s.users[u.ID] = &user
+	s.accessTokens[u.AccessToken] = u.ID
+	s.refreshTokens[u.RefreshToken] = u.ID
+	return &user
+}
+
+// AddArtists adds artists to the catalog served by the several artists
+// endpoint. The top artists of added users are registered automatically.
+func (s *Server) AddArtists(artists ...Artist) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, artist := range artists {
+		s.artists[artist.ID] = artist
+	}
+}
+
+// AddAudioFeatures adds tracks' audio features to the catalog served by the
+// several audio features endpoint
+func (s *Server) AddAudioFeatures(features ...AudioFeatures) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range features {
+		s.audioFeatures[f.ID] = f
+	}
+}
+
+// PlaylistItems returns the item URIs of a playlist created through the fake
+// server, or nil if there is no such playlist
+func (s *Server) PlaylistItems(playlistID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	playlist, ok := s.playlists[playlistID]
+	if !ok {
+		return nil
+	}
+	return append([]string{}, playlist.items...)
+}
+
+// UpdateUser mutates a registered user while holding the server lock
+func (s *Server) UpdateUser(userID string, fn func(u *User)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[userID]; ok {
+		fn(u)
+	}
+}
+
+// AddAuthCode registers a single-use authorization code for a user that can be
+// exchanged with the given PKCE code verifier
+func (s *Server) AddAuthCode(code, codeVerifier, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[code] = authCode{userID: userID, codeVerifier: codeVerifier}
+}
+
+// RevokeRefreshToken makes later refreshes with the token fail with invalid_grant
+func (s *Server) RevokeRefreshToken(refreshToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.refreshTokens, refreshToken)
+}
+
+// Fail scripts failures for every request to path until the failure is used up
+func (s *Server) Fail(path string, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[path] = &f
+}
+
+// ClearFailures removes all scripted failures
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = make(map[string]*Failure)
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// nextToken generates a unique token, the caller must hold the lock
+func (s *Server) nextToken(prefix string) string {
+	s.tokenSeq++
+	return fmt.Sprintf("%s-%d", prefix, s.tokenSeq)
+}
+
+// recordAndFail records every request and answers scripted failures before
+// the request reaches the endpoint handlers
+func (s *Server) recordAndFail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Header: r.Header.Clone(),
+			Query:  r.URL.Query(),
+			Form:   r.PostForm,
+		})
+
+		failure, ok := s.failures[r.URL.Path]
+		var f Failure
+		if ok {
+			f = *failure
+			if failure.Times > 0 {
+				failure.Times--
+				if failure.Times == 0 {
+					delete(s.failures, r.URL.Path)
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if f.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(f.RetryAfter))
+		}
+		if f.Body != "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(f.Status)
+			_, _ = w.Write([]byte(f.Body))
+			return
+		}
+		writeError(w, f.Status, http.StatusText(f.Status))
+	})
+}
+
+// withUser resolves the bearer token to a user before calling the handler
+func (s *Server) withUser(fn func(w http.ResponseWriter, r *http.Request, u *User)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		s.mu.Lock()
+		var user *User
+		if userID, ok := s.accessTokens[token]; ok {
+			copied := *s.users[userID]
+			user = &copied
+		}
+		s.mu.Unlock()
+
+		if user == nil {
+			writeError(w, http.StatusUnauthorized, "Invalid access token")
+			return
+		}
+
+		fn(w, r, user)
+	}
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Both grants of a PKCE client identify it by client ID in the body
+	if r.PostForm.Get("client_id") != ClientID {
+		writeOAuthError(w, "invalid_client", "Invalid client")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var userID string
+	refreshToken := ""
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, ok := s.codes[r.PostForm.Get("code")]
+		if !ok || code.codeVerifier != r.PostForm.Get("code_verifier") {
+			writeOAuthError(w, "invalid_grant", "Invalid authorization code")
+			return
+		}
+		delete(s.codes, r.PostForm.Get("code"))
+		userID = code.userID
+
+		refreshToken = s.nextToken("refresh")
+		s.refreshTokens[refreshToken] = userID
+		s.users[userID].RefreshToken = refreshToken
+	case "refresh_token":
+		var ok bool
+		userID, ok = s.refreshTokens[r.PostForm.Get("refresh_token")]
+		if !ok {
+			writeOAuthError(w, "invalid_grant", "Invalid refresh token")
+			return
+		}
+
+		if s.RotateRefreshTokens {
+			delete(s.refreshTokens, r.PostForm.Get("refresh_token"))
+			refreshToken = s.nextToken("refresh")
+			s.refreshTokens[refreshToken] = userID
+			s.users[userID].RefreshToken = refreshToken
+		}
+	default:
+		writeOAuthError(w, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+
+	accessToken := s.nextToken("access")
+	s.accessTokens[accessToken] = userID
+	s.users[userID].AccessToken = accessToken
+
+	writeJSON(w, http.StatusOK, spotify.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.TokenLifetime.Seconds()),
+		RefreshToken: refreshToken,
+	})
+}
+
+// handleArtists serves the catalog entries for a comma separated list of ids,
+// with null for unknown ids like Spotify
+func (s *Server) handleArtists(w http.ResponseWriter, r *http.Request, u *User) {
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) == 0 || len(ids) > 50 || ids[0] == "" {
+		writeError(w, http.StatusBadRequest, "Invalid ids")
+		return
+	}
+
+	s.mu.Lock()
+	artists := make([]*Artist, len(ids))
+	for i, id := range ids {
+		if artist, ok := s.artists[id]; ok {
+			artists[i] = &artist
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"artists": artists})
+}
+
+// handleAudioFeatures serves the catalog entries for a comma separated list of
+// track ids, with null for unknown ids like Spotify
+func (s *Server) handleAudioFeatures(w http.ResponseWriter, r *http.Request, u *User) {
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) == 0 || len(ids) > 100 || ids[0] == "" {
+		writeError(w, http.StatusBadRequest, "Invalid ids")
+		return
+	}
+
+	s.mu.Lock()
+	features := make([]*AudioFeatures, len(ids))
+	for i, id := range ids {
+		if f, ok := s.audioFeatures[id]; ok {
+			features[i] = &f
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"audio_features": features})
+}
+
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request, u *User) {
+	writeJSON(w, http.StatusOK, spotify.CurrentUser{
+		ID:          u.ID,
+		DisplayName: u.DisplayName,
+		URI:         "spotify:user:" + u.ID,
+	})
+}
+
+// handlePlayer serves the full playback state including the device
+func (s *Server) handlePlayer(w http.ResponseWriter, r *http.Request, u *User) {
+	s.writePlayback(w, r, u, true)
+}
+
+// handleCurrentlyPlaying serves the playback state without the device
+func (s *Server) handleCurrentlyPlaying(w http.ResponseWriter, r *http.Request, u *User) {
+	s.writePlayback(w, r, u, false)
+}
+
+func (s *Server) writePlayback(w http.ResponseWriter, r *http.Request, u *User, withDevice bool) {
+	if u.CurrentlyPlaying == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	playback := *u.CurrentlyPlaying
+	if !withDevice {
+		playback.Device = nil
+	}
+	if playback.CurrentlyPlayingType == "" {
+		playback.CurrentlyPlayingType = "track"
+		if playback.Episode != nil {
+			playback.CurrentlyPlayingType = "episode"
+		}
+	}
+	if playback.Timestamp == 0 {
+		playback.Timestamp = time.Now().UnixMilli()
+	}
+
+	// Like Spotify, episodes are only returned when the client asks for them
+	if playback.Episode != nil && !strings.Contains(r.URL.Query().Get("additional_types"), "episode") {
+		playback.Episode = nil
+	}
+	writeJSON(w, http.StatusOK, playback)
+}
+
+// handleRecentlyPlayed serves the plays after the "after" cursor. Pages are
+// returned newest first and walk forward in time through the next link.
+func (s *Server) handleRecentlyPlayed(w http.ResponseWriter, r *http.Request, u *User) {
+	limit := queryInt(r, "limit", 20)
+	if limit < 1 || limit > 50 {
+		writeError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	after := int64(0)
+	if value := r.URL.Query().Get("after"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid after")
+			return
+		}
+		after = n
+	}
+
+	var plays []PlayHistory
+	for _, play := range u.RecentlyPlayed {
+		if play.PlayedAt.UnixMilli() > after {
+			play.Track = withTrackType(play.Track)
+			plays = append(plays, play)
+		}
+	}
+	sort.Slice(plays, func(i, j int) bool {
+		return plays[i].PlayedAt.Before(plays[j].PlayedAt)
+	})
+
+	more := len(plays) > limit
+	if more {
+		plays = plays[:limit]
+	}
+
+	var cursors map[string]string
+	var next *string
+	if len(plays) > 0 {
+		newest := strconv.FormatInt(plays[len(plays)-1].PlayedAt.UnixMilli(), 10)
+		cursors = map[string]string{
+			"after":  newest,
+			"before": strconv.FormatInt(plays[0].PlayedAt.UnixMilli(), 10),
+		}
+		if more {
+			nextURL := *r.URL
+			query := nextURL.Query()
+			query.Set("after", newest)
+			query.Set("limit", strconv.Itoa(limit))
+			nextURL.RawQuery = query.Encode()
+			nextURL.Scheme = "http"
+			nextURL.Host = r.Host
+			u := nextURL.String()
+			next = &u
+		}
+	}
+
+	items := make([]PlayHistory, len(plays))
+	for i := range plays {
+		items[i] = plays[len(plays)-1-i]
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":   items,
+		"limit":   limit,
+		"cursors": cursors,
+		"next":    next,
+	})
+}
+
+func (s *Server) handleTopArtists(w http.ResponseWriter, r *http.Request, u *User) {
+	items := make([]interface{}, len(u.TopArtists))
+	for i := range u.TopArtists {
+		items[i] = u.TopArtists[i]
+	}
+	writePage(w, r, items)
+}
+
+func (s *Server) handleTopTracks(w http.ResponseWriter, r *http.Request, u *User) {
+	items := make([]interface{}, len(u.TopTracks))
+	for i := range u.TopTracks {
+		items[i] = withTrackType(u.TopTracks[i])
+	}
+	writePage(w, r, items)
+}
+
+func (s *Server) handlePlaylists(w http.ResponseWriter, r *http.Request, u *User) {
+	items := make([]interface{}, len(u.Playlists))
+	for i := range u.Playlists {
+		items[i] = u.Playlists[i]
+	}
+	writePage(w, r, items)
+}
+
+// handleCreatePlaylist creates an empty playlist and adds it to the user's
+// saved playlists
+func (s *Server) handleCreatePlaylist(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if r.PathValue("userID") != u.ID {
+		writeError(w, http.StatusForbidden, "You cannot create a playlist for another user")
+		return
+	}
+
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      *bool  `json:"public"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		writeError(w, http.StatusBadRequest, "Missing required field: name")
+		return
+	}
+
+	s.mu.Lock()
+	id := s.nextToken("playlist")
+	playlist := Playlist{
+		ID:     id,
+		Name:   body.Name,
+		URI:    "spotify:playlist:" + id,
+		Public: body.Public == nil || *body.Public,
+		Images: []Image{},
+	}
+	s.playlists[id] = &createdPlaylist{ownerID: u.ID}
+	s.users[u.ID].Playlists = append(s.users[u.ID].Playlists, playlist)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, playlist)
+}
+
+// handlePlaylistItems appends (POST) or replaces (PUT) the items of a
+// playlist created through the fake server
+func (s *Server) handlePlaylistItems(w http.ResponseWriter, r *http.Request, u *User) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body struct {
+		URIs []string `json:"uris"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.URIs) > 100 {
+		writeError(w, http.StatusBadRequest, "Invalid uris")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	playlist, ok := s.playlists[r.PathValue("playlistID")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	if playlist.ownerID != u.ID {
+		writeError(w, http.StatusForbidden, "You cannot edit another user's playlist")
+		return
+	}
+
+	status := http.StatusCreated
+	if r.Method == http.MethodPut {
+		playlist.items = nil
+		status = http.StatusOK
+	}
+	playlist.items = append(playlist.items, body.URIs...)
+
+	writeJSON(w, status, map[string]string{"snapshot_id": s.nextToken("snapshot")})
+}
+
+// withTrackType fills in the object type Spotify always sends for tracks
+func withTrackType(t Track) Track {
+	if t.Type == "" {
+		t.Type = "track"
+	}
+	return t
+}
+
+// writePage writes an offset/limit paging object like the Spotify API does
+func writePage(w http.ResponseWriter, r *http.Request, items []interface{}) {
+	limit := queryInt(r, "limit", 20)
+	offset := queryInt(r, "offset", 0)
+	if limit < 1 || limit > 50 || offset < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid limit or offset")
+		return
+	}
+
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	page := []interface{}{}
+	if offset < len(items) {
+		page = items[offset:end]
+	}
+
+	var next *string
+	if end < len(items) {
+		nextURL := *r.URL
+		query := nextURL.Query()
+		query.Set("offset", strconv.Itoa(end))
+		query.Set("limit", strconv.Itoa(limit))
+		nextURL.RawQuery = query.Encode()
+		nextURL.Scheme = "http"
+		nextURL.Host = r.Host
+		u := nextURL.String()
+		next = &u
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":  page,
+		"limit":  limit,
+		"offset": offset,
+		"total":  len(items),
+		"next":   next,
+	})
+}
+
+func queryInt(r *http.Request, key string, defaultValue int) int {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a Web API style error object
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"status":  status,
+			"message": message,
+		},
+	})
+}
+
+// writeOAuthError writes an accounts service style error object
+func writeOAuthError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
@@ -0,0 +1,204 @@
+// Package tokens keeps users' Spotify access tokens fresh
+package tokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matchmyvibe/backend/internal/db"
+	"github.com/matchmyvibe/backend/internal/models"
+	"github.com/matchmyvibe/backend/internal/spotify"
+)
+
+// ErrNeedsReauth is returned when a user's Spotify refresh token was revoked
+// and they have to log in again
+var ErrNeedsReauth = errors.New("spotify authorization revoked, user must log in again")
+
+// refreshTimeout bounds a single refresh. Refreshes are shared between callers,
+// so they don't inherit the deadline of whichever request started them.
+const refreshTimeout = 30 * time.Second
+
+// Token is a user's current set of Spotify tokens
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// Manager refreshes Spotify tokens before they expire and hands out valid
+// access tokens. Concurrent refreshes for the same user are collapsed into one.
+type Manager struct {
+	DB            *db.DB
+	SpotifyClient *spotify.Client
+
+	// RefreshWindow is how long before expiry a token is refreshed
+	RefreshWindow time.Duration
+	// Interval is how often the background loop looks for expiring tokens
+	Interval time.Duration
+	// BatchSize is the maximum number of users refreshed per background run
+	BatchSize int
+	// ActiveWindow limits background refreshes to users active this recently,
+	// the tokens of other users are refreshed when they are next needed
+	ActiveWindow time.Duration
+	// RetryBackoff is how long the background loop waits before retrying a
+	// failed refresh, doubled on every further failure up to MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+
+	group flightGroup
+}
+
+// New creates a new token Manager with default settings
+func New(database *db.DB, spotifyClient *spotify.Client) *Manager {
+	return &Manager{
+		DB:              database,
+		SpotifyClient:   spotifyClient,
+		RefreshWindow:   5 * time.Minute,
+		Interval:        time.Minute,
+		BatchSize:       100,
+		ActiveWindow:    14 * 24 * time.Hour,
+		RetryBackoff:    time.Minute,
+		MaxRetryBackoff: 6 * time.Hour,
+	}
+}
+
+// Start refreshes tokens nearing expiry in the background until ctx is done
+func (m *Manager) Start(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.refreshExpiring(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// AccessToken returns a valid access token for the user, refreshing it first
+// if it expires within the refresh window. The user's token fields are updated
+// in place.
+func (m *Manager) AccessToken(ctx context.Context, user *models.User) (string, error) {
+	if user.NeedsReauth {
+		return "", ErrNeedsReauth
+	}
+
+	if time.Until(user.TokenExpiry) > m.RefreshWindow {
+		return user.AccessToken, nil
+	}
+
+	token, err := m.Refresh(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	user.AccessToken = token.AccessToken
+	user.RefreshToken = token.RefreshToken
+	user.TokenExpiry = token.Expiry
+	return user.AccessToken, nil
+}
+
+// Refresh refreshes the user's tokens unless another caller already did
+func (m *Manager) Refresh(ctx context.Context, userID uuid.UUID) (Token, error) {
+	return m.group.Do(userID, func() (Token, error) {
+		refreshCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+		defer cancel()
+
+		return m.refresh(refreshCtx, userID)
+	})
+}
+
+// refresh reads the latest tokens from the database and refreshes them with
+// Spotify if they are still close to expiry
+func (m *Manager) refresh(ctx context.Context, userID uuid.UUID) (Token, error) {
+	user, err := m.DB.GetUserByID(ctx, userID)
+	if err != nil {
+		return Token{}, fmt.Errorf("error retrieving user: %w", err)
+	}
+	if user == nil {
+		return Token{}, fmt.Errorf("user %s not found", userID)
+	}
+	if user.NeedsReauth {
+		return Token{}, ErrNeedsReauth
+	}
+
+	current := Token{AccessToken: user.AccessToken, RefreshToken: user.RefreshToken, Expiry: user.TokenExpiry}
+
+	// Another instance may have refreshed since the caller loaded the user
+	if time.Until(user.TokenExpiry) > m.RefreshWindow {
+		return current, nil
+	}
+
+	tokenResponse, err := m.SpotifyClient.RefreshToken(ctx, user.RefreshToken)
+	if err != nil {
+		if errors.Is(err, spotify.ErrInvalidGrant) {
+			return m.handleInvalidGrant(ctx, user)
+		}
+		// Keep a user whose refreshes keep failing from taking up every batch
+		if recordErr := m.DB.RecordTokenRefreshFailure(ctx, user.ID, m.RetryBackoff, m.MaxRetryBackoff); recordErr != nil {
+			fmt.Printf("[ERROR] Token refresh for user %s - Error recording failure: %v\n", user.ID, recordErr)
+		}
+		return Token{}, err
+	}
+
+	token := Token{
+		AccessToken:  tokenResponse.AccessToken,
+		RefreshToken: tokenResponse.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second),
+	}
+
+	// Spotify only sends a refresh token when it rotates it, keep the old one otherwise
+	if token.RefreshToken == "" {
+		token.RefreshToken = user.RefreshToken
+	}
+
+	if err := m.DB.UpdateSpotifyTokens(ctx, user.ID, token.AccessToken, token.RefreshToken, token.Expiry); err != nil {
+		return Token{}, fmt.Errorf("error updating user tokens: %w", err)
+	}
+
+	return token, nil
+}
+
+// handleInvalidGrant marks the user as needing to log in again, unless the
+// refresh token was rotated by another instance while we were refreshing
+func (m *Manager) handleInvalidGrant(ctx context.Context, user *models.User) (Token, error) {
+	latest, err := m.DB.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return Token{}, fmt.Errorf("error retrieving user: %w", err)
+	}
+	if latest != nil && latest.RefreshToken != user.RefreshToken && time.Until(latest.TokenExpiry) > 0 {
+		return Token{AccessToken: latest.AccessToken, RefreshToken: latest.RefreshToken, Expiry: latest.TokenExpiry}, nil
+	}
+
+	fmt.Printf("[ERROR] Token refresh for user %s was rejected, marking for re-authentication\n", user.ID)
+	if err := m.DB.MarkNeedsReauth(ctx, user.ID); err != nil {
+		return Token{}, fmt.Errorf("error marking user for re-authentication: %w", err)
+	}
+	return Token{}, ErrNeedsReauth
+}
+
+// refreshExpiring refreshes one batch of recently active users' tokens that
+// expire within the refresh window
+func (m *Manager) refreshExpiring(ctx context.Context) {
+	now := time.Now()
+	users, err := m.DB.GetUsersWithExpiringTokens(ctx, now.Add(m.RefreshWindow), now.Add(-m.ActiveWindow), m.BatchSize)
+	if err != nil {
+		fmt.Printf("[ERROR] Token manager - Error retrieving expiring tokens: %v\n", err)
+		return
+	}
+
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := m.Refresh(ctx, user.ID); err != nil && !errors.Is(err, ErrNeedsReauth) {
+			fmt.Printf("[ERROR] Token manager - Error refreshing token for user %s: %v\n", user.ID, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package tokens
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// errRefreshPanicked is returned to callers that waited on a refresh that panicked
+var errRefreshPanicked = errors.New("tokens: token refresh panicked")
+
+// call is a refresh in flight or completed
+type call struct {
+	wg    sync.WaitGroup
+	token Token
+	err   error
+}
+
+// flightGroup makes sure only one refresh runs per user at a time. Callers
+// that arrive while a refresh is running wait for it and share its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[uuid.UUID]*call
+}
+
+// Do runs fn for the user unless a call for the same user is already running,
+// in which case it waits for that call and returns its result
+func (g *flightGroup) Do(userID uuid.UUID, fn func() (Token, error)) (Token, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[uuid.UUID]*call)
+	}
+	if c, ok := g.calls[userID]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.token, c.err
+	}
+
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[userID] = c
+	g.mu.Unlock()
+
+	// Clean up even if fn panics, otherwise later refreshes for the user would
+	// wait on this call forever
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, userID)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	// Callers waiting on a fn that panics get this error instead of a zero Token
+	c.err = errRefreshPanicked
+	c.token, c.err = fn()
+	return c.token, c.err
+}
@@ -0,0 +1,86 @@
+package tokens
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFlightGroupSharesResult(t *testing.T) {
+	var g flightGroup
+	userID := uuid.New()
+	release := make(chan struct{})
+	calls := 0
+
+	var wg sync.WaitGroup
+	results := make([]Token, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.Do(userID, func() (Token, error) {
+				calls++
+				<-release
+				return Token{AccessToken: "shared"}, nil
+			})
+		}(i)
+	}
+
+	// Let every caller reach Do before the refresh finishes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("refreshes = %d, want 1", calls)
+	}
+	for i, token := range results {
+		if token.AccessToken != "shared" {
+			t.Errorf("results[%d] = %+v, want the shared token", i, token)
+		}
+	}
+}
+
+func TestFlightGroupRecoversFromPanic(t *testing.T) {
+	var g flightGroup
+	userID := uuid.New()
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	go func() {
+		defer func() { recover() }()
+		g.Do(userID, func() (Token, error) {
+			close(started)
+			<-release
+			panic("refresh failed")
+		})
+	}()
+	<-started
+
+	// A caller waiting on the panicking refresh gets an error
+	waited := make(chan error)
+	go func() {
+		_, err := g.Do(userID, func() (Token, error) { return Token{}, nil })
+		waited <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-waited:
+		if !errors.Is(err, errRefreshPanicked) {
+			t.Errorf("waiting caller error = %v, want errRefreshPanicked", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting caller never returned")
+	}
+
+	// Later refreshes for the user run instead of waiting forever
+	token, err := g.Do(userID, func() (Token, error) { return Token{AccessToken: "fresh"}, nil })
+	if err != nil || token.AccessToken != "fresh" {
+		t.Errorf("Do() after a panic = %+v, %v, want a fresh token", token, err)
+	}
+}
@@ -1,6 +1,6 @@
 #!/bin/bash
 
-# Authenticate with test credentials
+# Authenticate with test credentials (requires SPOTIFY_ALLOW_LEGACY_AUTH=true)
 curl -X POST http://localhost:8080/auth/spotify \
   -H "Content-Type: application/json" \
   -d '{